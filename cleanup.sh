@@ -7,4 +7,4 @@ rm vault_session
 
 rm ./storage/metadata.json
 
-rm ./storage/uploads/*
\ No newline at end of file
+rm -r ./storage/uploads/*
\ No newline at end of file
@@ -19,9 +19,32 @@ func Help() {
 	println("Available commands:")
 	println("  help    - Show this help message")
 	println("  exit    - Exit the application")
-	println("  read    - Displays metadata for a specific file")
+	println("  info <fileId> - Displays metadata for a specific file (also: read)")
 	println("  vault   - Manage vaults")
-	println("  upload <filepath> - Manage files in vaults")
+	println("  upload <filepath|pattern>... [--to <folder>] [--jobs N] - Manage files in vaults")
+	println("  upload -r <dir> [--to <folder>] [--jobs N] - Upload a directory tree, skipping what its .vaultignore lists")
+	println("  upload --resume [filepath] - Continue an interrupted upload")
+	println("  list [--sort name|size|date] [--desc] [--limit N] [--offset N] [--name filter] [--tag tag] [--shared] - List your files")
+	println("  download <fileId> [dest] - Retrieve a file from the vault")
+	println("  versions <fileId> - List the versions of a file")
+	println("  restore <fileId> --version N - Restore an earlier version of a file")
+	println("  trash list|restore <fileId>|empty - Manage deleted files")
+	println("  quota - Show your used and available storage")
+	println("  import [path] - Import a legacy metadata.json")
+	println("  export <path> - Export your file metadata as JSON")
+	println("  db status - Show the database schema version")
+	println("  share <fileId> <email> [--write] - Share a file with another user")
+	println("  unshare <fileId> <email> - Stop sharing a file with a user")
+	println("  tag add|remove <fileId> <tag> - Label a file with tags")
+	println("  attr list|set|remove <fileId> [key=value] - Manage the attributes of a file")
+	println("  search <query> - Find files, e.g. search name:*.pdf size>10MB tag:invoice")
+	println("  grep <terms>... [--limit N] - Search inside your text files")
+	println("  mkdir <path> [--parents] | rmdir <path> - Create or remove folders")
+	println("  ls [path] | tree [path] - Show what's in a folder")
+	println("  cd [path] | pwd - Change or show the current folder")
+	println("  rename <fileId> <newName> - Rename a file")
+	println("  mv <fileId> <folder> - Move a file to another folder")
+	println("  fsck [--repair] - Check the vault for inconsistencies (--repair only for FILEVAULT_ADMINS)")
 }
 
 // Display exit message
@@ -48,7 +71,31 @@ func IsValidCommand(command string) bool {
 		"upload",
 		"list",
 		"read",
+		"info",
 		"delete",
+		"download",
+		"versions",
+		"restore",
+		"trash",
+		"quota",
+		"import",
+		"export",
+		"db",
+		"share",
+		"unshare",
+		"tag",
+		"attr",
+		"search",
+		"grep",
+		"mkdir",
+		"rmdir",
+		"ls",
+		"tree",
+		"cd",
+		"pwd",
+		"rename",
+		"mv",
+		"fsck",
 	}
 	return slices.Contains(validCommands, command)
 }
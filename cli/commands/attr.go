@@ -0,0 +1,55 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+	"strings"
+)
+
+type AttrCommand struct {
+	fileService *services.FileService
+}
+
+func NewAttrCommand(fileService *services.FileService) ICommand {
+	return &AttrCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *AttrCommand) Execute(args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: attr list <fileId> | attr set <fileId> key=value | attr remove <fileId> <key>")
+	}
+
+	switch args[0] {
+	case "list":
+		if len(args) != 2 {
+			return errors.New("usage: attr list <fileId>")
+		}
+		return c.fileService.ListAttributes(args[1])
+	case "set":
+		if len(args) != 3 {
+			return errors.New("usage: attr set <fileId> key=value")
+		}
+		key, value, ok := strings.Cut(args[2], "=")
+		if !ok {
+			return errors.New("attributes are set as key=value")
+		}
+		return c.fileService.SetAttribute(args[1], key, value)
+	case "remove":
+		if len(args) != 3 {
+			return errors.New("usage: attr remove <fileId> <key>")
+		}
+		return c.fileService.RemoveAttribute(args[1], args[2])
+	default:
+		return errors.New("unknown attr subcommand. Use list, set or remove")
+	}
+}
+
+func (c *AttrCommand) Name() string {
+	return "attr"
+}
+
+func (c *AttrCommand) HelpContent() string {
+	return "attr list <fileId> | attr set <fileId> key=value | attr remove <fileId> <key> - Manages the tags and key/value attributes of a file"
+}
@@ -0,0 +1,35 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type CdCommand struct {
+	fileService *services.FileService
+}
+
+func NewCdCommand(fileService *services.FileService) ICommand {
+	return &CdCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *CdCommand) Execute(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: cd [path]")
+	}
+	path := ""
+	if len(args) == 1 {
+		path = args[0]
+	}
+	return c.fileService.ChangeFolder(path)
+}
+
+func (c *CdCommand) Name() string {
+	return "cd"
+}
+
+func (c *CdCommand) HelpContent() string {
+	return "cd [path] - Changes the current folder, back to the root without a path"
+}
@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type DBCommand struct {
+	dbService *services.DBService
+}
+
+func NewDBCommand(dbService *services.DBService) ICommand {
+	return &DBCommand{
+		dbService: dbService,
+	}
+}
+
+func (c *DBCommand) Execute(args []string) error {
+	if len(args) != 1 || args[0] != "status" {
+		return errors.New("usage: db status")
+	}
+	return c.dbService.Status()
+}
+
+func (c *DBCommand) Name() string {
+	return "db"
+}
+
+func (c *DBCommand) HelpContent() string {
+	return "db status - Shows the schema version of the database and the migrations applied to it"
+}
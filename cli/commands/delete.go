@@ -34,7 +34,7 @@ func (c *DeleteCommand) Name() string {
 
 func (c *DeleteCommand) HelpContent() string {
 	return `
-			Deletes a file from the vault. Usage: delete <filepath>.
-			Eg vault delete /file.txt
+			Moves a file to the trash. Usage: delete <fileId>.
+			Eg vault delete 6ee1728f-cb68-40bc-9886-2173e232e854
 		`
 }
@@ -0,0 +1,39 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type DownloadCommand struct {
+	fileService *services.FileService
+}
+
+func NewDownloadCommand(fileService *services.FileService) ICommand {
+	return &DownloadCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *DownloadCommand) Execute(args []string) error {
+	positional, flags := splitFlags(args)
+	if len(positional) < 1 || len(positional) > 2 {
+		return errors.New("usage: download <fileId> [destination] [--force]")
+	}
+	fileId := positional[0]
+	destination := ""
+	if len(positional) == 2 {
+		destination = positional[1]
+	}
+	_, force := flags["force"]
+
+	return c.fileService.DownloadFile(fileId, destination, force)
+}
+
+func (c *DownloadCommand) Name() string {
+	return "download"
+}
+
+func (c *DownloadCommand) HelpContent() string {
+	return "download <fileId> [destination] [--force] - Retrieves a file you own from the vault. Use --force to overwrite an existing destination"
+}
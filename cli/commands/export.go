@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type ExportCommand struct {
+	fileService *services.FileService
+}
+
+func NewExportCommand(fileService *services.FileService) ICommand {
+	return &ExportCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *ExportCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: export <path>")
+	}
+	return c.fileService.ExportMetadata(args[0])
+}
+
+func (c *ExportCommand) Name() string {
+	return "export"
+}
+
+func (c *ExportCommand) HelpContent() string {
+	return "export <path> - Writes the metadata of your files to a JSON file"
+}
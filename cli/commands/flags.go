@@ -0,0 +1,50 @@
+package commands
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// splitFlags separates "--name" style flags from positional arguments.
+// Flags listed in valueFlags take a value, either as "--name=value" or as the
+// following argument. Every other flag is treated as a boolean switch.
+func splitFlags(args []string, valueFlags ...string) ([]string, map[string]string) {
+	positional := []string{}
+	flags := make(map[string]string)
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "--") || arg == "--" {
+			positional = append(positional, arg)
+			continue
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		if !hasValue {
+			value = "true"
+			for _, valueFlag := range valueFlags {
+				if name == valueFlag && i+1 < len(args) {
+					i++
+					value = args[i]
+					break
+				}
+			}
+		}
+		flags[name] = value
+	}
+	return positional, flags
+}
+
+// intFlag returns the value of a non-negative integer flag, 0 when unset.
+func intFlag(flags map[string]string, name string) (int, error) {
+	value, ok := flags[name]
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("--%s must be a non-negative number", name)
+	}
+	return n, nil
+}
@@ -0,0 +1,33 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type FsckCommand struct {
+	fileService *services.FileService
+}
+
+func NewFsckCommand(fileService *services.FileService) ICommand {
+	return &FsckCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *FsckCommand) Execute(args []string) error {
+	positional, flags := splitFlags(args)
+	if len(positional) > 0 {
+		return errors.New("usage: fsck [--repair]")
+	}
+	_, repair := flags["repair"]
+	return c.fileService.Fsck(repair)
+}
+
+func (c *FsckCommand) Name() string {
+	return "fsck"
+}
+
+func (c *FsckCommand) HelpContent() string {
+	return "fsck [--repair] - Checks that the database and the stored content agree. --repair quarantines unused content and removes records of missing content. Only FILEVAULT_ADMINS can repair, and check too once that is set"
+}
@@ -0,0 +1,39 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type GrepCommand struct {
+	fileService *services.FileService
+}
+
+func NewGrepCommand(fileService *services.FileService) ICommand {
+	return &GrepCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *GrepCommand) Execute(args []string) error {
+	terms, flags := splitFlags(args, "limit")
+	if _, ok := flags["reindex"]; ok {
+		return c.fileService.ReindexContent()
+	}
+	if len(terms) == 0 {
+		return errors.New("usage: grep <terms>... [--limit N] | grep --reindex")
+	}
+	limit, err := intFlag(flags, "limit")
+	if err != nil {
+		return err
+	}
+	return c.fileService.Grep(terms, limit)
+}
+
+func (c *GrepCommand) Name() string {
+	return "grep"
+}
+
+func (c *GrepCommand) HelpContent() string {
+	return "grep <terms>... [--limit N] | grep --reindex - Searches inside your text files, best matches first. A trailing * matches by prefix. --reindex rebuilds the index of your files. Needs a build with -tags sqlite_fts5 (make build) and FILEVAULT_INDEX_CONTENT=true"
+}
@@ -0,0 +1,35 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type ImportCommand struct {
+	fileService *services.FileService
+}
+
+func NewImportCommand(fileService *services.FileService) ICommand {
+	return &ImportCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *ImportCommand) Execute(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: import [path]")
+	}
+	path := services.DefaultMetadataPath
+	if len(args) == 1 {
+		path = args[0]
+	}
+	return c.fileService.ImportMetadata(path)
+}
+
+func (c *ImportCommand) Name() string {
+	return "import"
+}
+
+func (c *ImportCommand) HelpContent() string {
+	return "import [path] - One-time import of a legacy metadata.json (default ./storage/metadata.json) into the database. Imported files belong to you"
+}
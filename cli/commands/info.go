@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type InfoCommand struct {
+	fileService *services.FileService
+}
+
+func NewInfoCommand(fileService *services.FileService) ICommand {
+	return &InfoCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *InfoCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: info <fileId>")
+	}
+	return c.fileService.FileInfo(args[0])
+}
+
+func (c *InfoCommand) Name() string {
+	return "info"
+}
+
+func (c *InfoCommand) HelpContent() string {
+	return "info <fileId> - Displays the metadata of a file: owner, size, type, checksum, versions, tags and sharing. Also available as read"
+}
@@ -17,12 +17,28 @@ func NewListCommand(fileService *services.FileService) ICommand {
 }
 
 func (c *ListCommand) Execute(args []string) error {
-	if len(args) > 0 {
-		return errors.New("list command doesn't require any arguments")
+	positional, flags := splitFlags(args, "sort", "limit", "offset", "name", "tag")
+	if len(positional) > 0 {
+		return errors.New("usage: list [--sort name|size|date] [--desc] [--limit N] [--offset N] [--name filter] [--tag tag] [--shared]")
+	}
+
+	opts := services.ListOptions{
+		SortBy:     flags["sort"],
+		NameFilter: flags["name"],
+		Tag:        flags["tag"],
+	}
+	_, opts.Descending = flags["desc"]
+	_, opts.SharedWithMe = flags["shared"]
+	var err error
+	if opts.Limit, err = intFlag(flags, "limit"); err != nil {
+		return err
+	}
+	if opts.Offset, err = intFlag(flags, "offset"); err != nil {
+		return err
 	}
 
 	fmt.Println("Listing file metadata")
-	err := c.fileService.ListUploaded()
+	err = c.fileService.ListUploaded(opts)
 	if err != nil {
 		return err
 	}
@@ -36,5 +52,5 @@ func (c *ListCommand) Name() string {
 }
 
 func (c *ListCommand) HelpContent() string {
-	return "Lists all uploaded files with basic metadata."
+	return "list [--sort name|size|date] [--desc] [--limit N] [--offset N] [--name filter] [--tag tag] [--shared] - Lists your files, or with --shared the files shared with you, with basic metadata. The name filter is a glob like *.pdf or text the name contains"
 }
@@ -0,0 +1,35 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type LsCommand struct {
+	fileService *services.FileService
+}
+
+func NewLsCommand(fileService *services.FileService) ICommand {
+	return &LsCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *LsCommand) Execute(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: ls [path]")
+	}
+	path := ""
+	if len(args) == 1 {
+		path = args[0]
+	}
+	return c.fileService.ListFolder(path)
+}
+
+func (c *LsCommand) Name() string {
+	return "ls"
+}
+
+func (c *LsCommand) HelpContent() string {
+	return "ls [path] - Lists the folders and files in a folder, the current one by default"
+}
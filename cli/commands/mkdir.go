@@ -0,0 +1,33 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type MkdirCommand struct {
+	fileService *services.FileService
+}
+
+func NewMkdirCommand(fileService *services.FileService) ICommand {
+	return &MkdirCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *MkdirCommand) Execute(args []string) error {
+	positional, flags := splitFlags(args)
+	if len(positional) != 1 {
+		return errors.New("usage: mkdir <path> [--parents]")
+	}
+	_, parents := flags["parents"]
+	return c.fileService.MakeFolder(positional[0], parents)
+}
+
+func (c *MkdirCommand) Name() string {
+	return "mkdir"
+}
+
+func (c *MkdirCommand) HelpContent() string {
+	return "mkdir <path> [--parents] - Creates a folder. --parents also creates missing folders along the path"
+}
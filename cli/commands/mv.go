@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type MvCommand struct {
+	fileService *services.FileService
+}
+
+func NewMvCommand(fileService *services.FileService) ICommand {
+	return &MvCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *MvCommand) Execute(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: mv <fileId> <folder>")
+	}
+	return c.fileService.MoveFile(args[0], args[1])
+}
+
+func (c *MvCommand) Name() string {
+	return "mv"
+}
+
+func (c *MvCommand) HelpContent() string {
+	return "mv <fileId> <folder> - Moves a file to another folder, keeping its ID and versions"
+}
@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type PwdCommand struct {
+	fileService *services.FileService
+}
+
+func NewPwdCommand(fileService *services.FileService) ICommand {
+	return &PwdCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *PwdCommand) Execute(args []string) error {
+	if len(args) > 0 {
+		return errors.New("usage: pwd")
+	}
+	return c.fileService.PrintWorkingFolder()
+}
+
+func (c *PwdCommand) Name() string {
+	return "pwd"
+}
+
+func (c *PwdCommand) HelpContent() string {
+	return "pwd - Shows the path of the current folder"
+}
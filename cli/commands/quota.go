@@ -0,0 +1,34 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type QuotaCommand struct {
+	fileService *services.FileService
+}
+
+func NewQuotaCommand(fileService *services.FileService) ICommand {
+	return &QuotaCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *QuotaCommand) Execute(args []string) error {
+	if len(args) == 0 {
+		return c.fileService.ShowQuota()
+	}
+	if args[0] == "set" && len(args) == 3 {
+		return c.fileService.SetQuota(args[1], args[2])
+	}
+	return errors.New("usage: quota | quota set <email> <size|default>")
+}
+
+func (c *QuotaCommand) Name() string {
+	return "quota"
+}
+
+func (c *QuotaCommand) HelpContent() string {
+	return "quota | quota set <email> <size|default> - Shows your used and available storage, counting older versions and the trash. Admins can override a user's quota, e.g. quota set bob@example.com 5GB"
+}
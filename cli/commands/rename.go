@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type RenameCommand struct {
+	fileService *services.FileService
+}
+
+func NewRenameCommand(fileService *services.FileService) ICommand {
+	return &RenameCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *RenameCommand) Execute(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: rename <fileId> <newName>")
+	}
+	return c.fileService.RenameFile(args[0], args[1])
+}
+
+func (c *RenameCommand) Name() string {
+	return "rename"
+}
+
+func (c *RenameCommand) HelpContent() string {
+	return "rename <fileId> <newName> - Renames a file, keeping its ID and versions"
+}
@@ -0,0 +1,37 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+	"strconv"
+)
+
+type RestoreCommand struct {
+	fileService *services.FileService
+}
+
+func NewRestoreCommand(fileService *services.FileService) ICommand {
+	return &RestoreCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *RestoreCommand) Execute(args []string) error {
+	positional, flags := splitFlags(args, "version")
+	if len(positional) != 1 || flags["version"] == "" {
+		return errors.New("usage: restore <fileId> --version <N>")
+	}
+	version, err := strconv.Atoi(flags["version"])
+	if err != nil || version < 1 {
+		return errors.New("version must be a positive number")
+	}
+	return c.fileService.RestoreVersion(positional[0], version)
+}
+
+func (c *RestoreCommand) Name() string {
+	return "restore"
+}
+
+func (c *RestoreCommand) HelpContent() string {
+	return "restore <fileId> --version <N> - Makes an earlier version of a file the current one"
+}
@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type RmdirCommand struct {
+	fileService *services.FileService
+}
+
+func NewRmdirCommand(fileService *services.FileService) ICommand {
+	return &RmdirCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *RmdirCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: rmdir <path>")
+	}
+	return c.fileService.RemoveFolder(args[0])
+}
+
+func (c *RmdirCommand) Name() string {
+	return "rmdir"
+}
+
+func (c *RmdirCommand) HelpContent() string {
+	return "rmdir <path> - Removes an empty folder"
+}
@@ -0,0 +1,32 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+	"strings"
+)
+
+type SearchCommand struct {
+	fileService *services.FileService
+}
+
+func NewSearchCommand(fileService *services.FileService) ICommand {
+	return &SearchCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *SearchCommand) Execute(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: search <query>, e.g. search name:*.pdf size>10MB uploaded>2025-01-01 tag:invoice")
+	}
+	return c.fileService.Search(strings.Join(args, " "))
+}
+
+func (c *SearchCommand) Name() string {
+	return "search"
+}
+
+func (c *SearchCommand) HelpContent() string {
+	return "search <query> - Finds your files by name:, size, uploaded, tag: and attr.<key>= terms, e.g. search name:*.pdf size>10MB uploaded>2025-01-01 tag:invoice. Prefix a term with - to exclude matches"
+}
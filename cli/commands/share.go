@@ -0,0 +1,36 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type ShareCommand struct {
+	fileService *services.FileService
+}
+
+func NewShareCommand(fileService *services.FileService) ICommand {
+	return &ShareCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *ShareCommand) Execute(args []string) error {
+	positional, flags := splitFlags(args)
+	if len(positional) != 2 {
+		return errors.New("usage: share <fileId> <email> [--write]")
+	}
+	permission := services.PermissionRead
+	if _, ok := flags["write"]; ok {
+		permission = services.PermissionWrite
+	}
+	return c.fileService.ShareFile(positional[0], positional[1], permission)
+}
+
+func (c *ShareCommand) Name() string {
+	return "share"
+}
+
+func (c *ShareCommand) HelpContent() string {
+	return "share <fileId> <email> [--write] - Lets another user download a file, or with --write also rename and delete it"
+}
@@ -0,0 +1,42 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type TagCommand struct {
+	fileService *services.FileService
+}
+
+func NewTagCommand(fileService *services.FileService) ICommand {
+	return &TagCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *TagCommand) Execute(args []string) error {
+	if len(args) < 3 {
+		return errors.New("usage: tag add <fileId> <tag>... | tag remove <fileId> <tag>")
+	}
+
+	switch args[0] {
+	case "add":
+		return c.fileService.AddTags(args[1], args[2:])
+	case "remove":
+		if len(args) != 3 {
+			return errors.New("usage: tag remove <fileId> <tag>")
+		}
+		return c.fileService.RemoveTag(args[1], args[2])
+	default:
+		return errors.New("unknown tag subcommand. Use add or remove")
+	}
+}
+
+func (c *TagCommand) Name() string {
+	return "tag"
+}
+
+func (c *TagCommand) HelpContent() string {
+	return "tag add <fileId> <tag>... | tag remove <fileId> <tag> - Labels files with tags, list them with list --tag <tag>"
+}
@@ -0,0 +1,44 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type TrashCommand struct {
+	fileService *services.FileService
+}
+
+func NewTrashCommand(fileService *services.FileService) ICommand {
+	return &TrashCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *TrashCommand) Execute(args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: trash list | trash restore <fileId> | trash empty")
+	}
+
+	switch args[0] {
+	case "list":
+		return c.fileService.ListTrash()
+	case "restore":
+		if len(args) != 2 {
+			return errors.New("usage: trash restore <fileId>")
+		}
+		return c.fileService.RestoreFromTrash(args[1])
+	case "empty":
+		return c.fileService.EmptyTrash()
+	default:
+		return errors.New("unknown trash subcommand. Use list, restore or empty")
+	}
+}
+
+func (c *TrashCommand) Name() string {
+	return "trash"
+}
+
+func (c *TrashCommand) HelpContent() string {
+	return "trash list | trash restore <fileId> | trash empty - Manages deleted files, which are purged automatically after the retention period"
+}
@@ -0,0 +1,35 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type TreeCommand struct {
+	fileService *services.FileService
+}
+
+func NewTreeCommand(fileService *services.FileService) ICommand {
+	return &TreeCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *TreeCommand) Execute(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: tree [path]")
+	}
+	path := ""
+	if len(args) == 1 {
+		path = args[0]
+	}
+	return c.fileService.Tree(path)
+}
+
+func (c *TreeCommand) Name() string {
+	return "tree"
+}
+
+func (c *TreeCommand) HelpContent() string {
+	return "tree [path] - Shows a folder and everything below it, the current folder by default"
+}
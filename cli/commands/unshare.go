@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type UnshareCommand struct {
+	fileService *services.FileService
+}
+
+func NewUnshareCommand(fileService *services.FileService) ICommand {
+	return &UnshareCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *UnshareCommand) Execute(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: unshare <fileId> <email>")
+	}
+	return c.fileService.UnshareFile(args[0], args[1])
+}
+
+func (c *UnshareCommand) Name() string {
+	return "unshare"
+}
+
+func (c *UnshareCommand) HelpContent() string {
+	return "unshare <fileId> <email> - Revokes the access a user was given to a file"
+}
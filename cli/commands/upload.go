@@ -3,6 +3,8 @@ package commands
 import (
 	"filevault/services"
 	"fmt"
+	"slices"
+	"strings"
 )
 
 type UploadCommand struct {
@@ -18,17 +20,44 @@ func NewUploadCommand(fileService *services.FileService) ICommand {
 
 // Execute runs the upload command with the provided arguments.
 func (c *UploadCommand) Execute(args []string) error {
-	if len(args) < 1 {
-		return fmt.Errorf("no file path provided")
+	positional, flags := splitFlags(args, "to", "jobs")
+	if _, ok := flags["resume"]; ok {
+		if len(positional) > 1 {
+			return fmt.Errorf("usage: upload --resume [filepath]")
+		}
+		if len(positional) == 0 {
+			return c.fileService.ResumeUpload("")
+		}
+		return c.fileService.ResumeUpload(positional[0])
 	}
-	filePath := args[0]
-	fmt.Printf("Uploading file: %s\n...", filePath)
-	err := c.fileService.UploadFile(filePath)
+	jobs, err := intFlag(flags, "jobs")
 	if err != nil {
-		return fmt.Errorf("failed to upload file: %w", err)
+		return err
+	}
+	// -r is the one short flag, splitFlags leaves it among the paths
+	if i := slices.Index(positional, "-r"); i >= 0 {
+		positional = slices.Delete(positional, i, i+1)
+		flags["recursive"] = "true"
+	}
+	if _, ok := flags["recursive"]; ok {
+		if len(positional) != 1 {
+			return fmt.Errorf("usage: upload -r <dir> [--to <folder>] [--jobs N]")
+		}
+		return c.fileService.UploadDirectory(positional[0], flags["to"], jobs)
+	}
+	if len(positional) < 1 {
+		return fmt.Errorf("no file path provided")
+	}
+	if len(positional) == 1 && !strings.ContainsAny(positional[0], "*?[") {
+		filePath := positional[0]
+		fmt.Printf("Uploading file: %s\n", filePath)
+		if err := c.fileService.UploadFile(filePath, flags["to"]); err != nil {
+			return fmt.Errorf("failed to upload file: %w", err)
+		}
+		fmt.Printf("Uploaded file: %s\n", filePath)
+		return nil
 	}
-	fmt.Printf("Uploaded file: %s\n...", filePath)
-	return nil
+	return c.fileService.UploadFiles(positional, flags["to"], jobs)
 }
 
 func (c *UploadCommand) Name() string {
@@ -36,5 +65,5 @@ func (c *UploadCommand) Name() string {
 }
 
 func (c *UploadCommand) HelpContent() string {
-    return "Upload a file to the vault. Usage: upload <filepath>"
+    return "Upload a file to the vault. Usage: upload <filepath|pattern>... [--to <folder>] [--jobs N] | upload -r <dir> [--to <folder>] to upload a directory tree | upload --resume [filepath] to continue an interrupted upload"
 }
\ No newline at end of file
@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"errors"
+	"filevault/services"
+)
+
+type VersionsCommand struct {
+	fileService *services.FileService
+}
+
+func NewVersionsCommand(fileService *services.FileService) ICommand {
+	return &VersionsCommand{
+		fileService: fileService,
+	}
+}
+
+func (c *VersionsCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: versions <fileId>")
+	}
+	return c.fileService.ListVersions(args[0])
+}
+
+func (c *VersionsCommand) Name() string {
+	return "versions"
+}
+
+func (c *VersionsCommand) HelpContent() string {
+	return "versions <fileId> - Lists the stored versions of a file"
+}
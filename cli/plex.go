@@ -11,20 +11,47 @@ import (
 // It holds and dispatches various commands.
 type CommandRouter struct {
 	commands map[string]commands.ICommand
+	// aliases map alternative names to the name of a registered command
+	aliases map[string]string
 }
 
-func NewCommandRouter(fs *services.FileService, as *services.AuthService) *CommandRouter {
+func NewCommandRouter(fs *services.FileService, as *services.AuthService, ds *services.DBService) *CommandRouter {
 	router := &CommandRouter{
 		commands: make(map[string]commands.ICommand),
+		aliases:  make(map[string]string),
 	}
 	
 
 	uploadCmd := commands.NewUploadCommand(fs)
 	listCmd := commands.NewListCommand(fs)
 	deleteCmd := commands.NewDeleteCommand(fs)
+	downloadCmd := commands.NewDownloadCommand(fs)
+	versionsCmd := commands.NewVersionsCommand(fs)
+	restoreCmd := commands.NewRestoreCommand(fs)
+	trashCmd := commands.NewTrashCommand(fs)
+	quotaCmd := commands.NewQuotaCommand(fs)
+	importCmd := commands.NewImportCommand(fs)
+	exportCmd := commands.NewExportCommand(fs)
+	shareCmd := commands.NewShareCommand(fs)
+	unshareCmd := commands.NewUnshareCommand(fs)
+	tagCmd := commands.NewTagCommand(fs)
+	attrCmd := commands.NewAttrCommand(fs)
+	searchCmd := commands.NewSearchCommand(fs)
+	grepCmd := commands.NewGrepCommand(fs)
+	mkdirCmd := commands.NewMkdirCommand(fs)
+	rmdirCmd := commands.NewRmdirCommand(fs)
+	lsCmd := commands.NewLsCommand(fs)
+	treeCmd := commands.NewTreeCommand(fs)
+	cdCmd := commands.NewCdCommand(fs)
+	pwdCmd := commands.NewPwdCommand(fs)
+	renameCmd := commands.NewRenameCommand(fs)
+	mvCmd := commands.NewMvCommand(fs)
+	infoCmd := commands.NewInfoCommand(fs)
+	fsckCmd := commands.NewFsckCommand(fs)
 	registerCmd := commands.NewRegisterCommand(as)
 	loginCmd := commands.NewLoginCommand(as)
 	logoutCmd := commands.NewLogoutCommand(as)
+	dbCmd := commands.NewDBCommand(ds)
 	// Test the command directly
 	fmt.Printf("Upload command name: %s\n", uploadCmd.Name())
 	fmt.Printf("Upload command help: %s\n", uploadCmd.HelpContent())
@@ -32,9 +59,34 @@ func NewCommandRouter(fs *services.FileService, as *services.AuthService) *Comma
 	router.RegisterCommand(uploadCmd)
 	router.RegisterCommand(listCmd)
 	router.RegisterCommand(deleteCmd)
+	router.RegisterCommand(downloadCmd)
+	router.RegisterCommand(versionsCmd)
+	router.RegisterCommand(restoreCmd)
+	router.RegisterCommand(trashCmd)
+	router.RegisterCommand(quotaCmd)
+	router.RegisterCommand(importCmd)
+	router.RegisterCommand(exportCmd)
+	router.RegisterCommand(shareCmd)
+	router.RegisterCommand(unshareCmd)
+	router.RegisterCommand(tagCmd)
+	router.RegisterCommand(attrCmd)
+	router.RegisterCommand(searchCmd)
+	router.RegisterCommand(grepCmd)
+	router.RegisterCommand(mkdirCmd)
+	router.RegisterCommand(rmdirCmd)
+	router.RegisterCommand(lsCmd)
+	router.RegisterCommand(treeCmd)
+	router.RegisterCommand(cdCmd)
+	router.RegisterCommand(pwdCmd)
+	router.RegisterCommand(renameCmd)
+	router.RegisterCommand(mvCmd)
+	router.RegisterCommand(infoCmd)
+	router.RegisterAlias("read", infoCmd.Name())
+	router.RegisterCommand(fsckCmd)
 	router.RegisterCommand(registerCmd)
 	router.RegisterCommand(loginCmd)
 	router.RegisterCommand(logoutCmd)
+	router.RegisterCommand(dbCmd)
 	router.RegisterCommand(&HelpCommand{router: router})
 	
 	return router
@@ -45,7 +97,15 @@ func (r *CommandRouter) RegisterCommand(cmd commands.ICommand) {
 	r.commands[cmd.Name()] = cmd
 }
 
+// RegisterAlias makes a registered command available under another name.
+func (r *CommandRouter) RegisterAlias(alias, commandName string) {
+	r.aliases[alias] = commandName
+}
+
 func (r *CommandRouter) ExecuteCommand(commandName string, args []string) error {
+	if target, ok := r.aliases[commandName]; ok {
+		commandName = target
+	}
 	cmd, exists := r.commands[commandName]
 	if !exists {
 		return fmt.Errorf("unknown command '%s'. Type 'help' for a list of commands.", commandName)
@@ -78,7 +138,11 @@ func (c *HelpCommand) Execute(args []string) error {
 	if len(args) > 1 {
 		// If user types "help <command_name>"
 		targetCmdName := strings.ToLower(args[1])
-		if cmd, found := c.router.commands[targetCmdName]; found {
+		commandName := targetCmdName
+		if target, ok := c.router.aliases[commandName]; ok {
+			commandName = target
+		}
+		if cmd, found := c.router.commands[commandName]; found {
 			fmt.Printf("Usage for '%s':\n  %s\n", targetCmdName, cmd.HelpContent())
 		} else {
 			return fmt.Errorf("help for unknown command '%s'", targetCmdName)
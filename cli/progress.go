@@ -0,0 +1,217 @@
+package cli
+
+import (
+	"filevault/services"
+	"filevault/utils"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// progressDelay is how long a transfer runs before its progress is
+	// shown, so small files don't flash a bar
+	progressDelay = 200 * time.Millisecond
+	// barRedraw is how often a progress bar is redrawn on a terminal
+	barRedraw = 100 * time.Millisecond
+	// lineInterval is how often a progress line is printed when stdout is
+	// redirected to a file or pipe
+	lineInterval = 5 * time.Second
+	barWidth     = 30
+)
+
+// ProgressBar draws the progress of transfers on stdout. On a terminal it
+// redraws a bar with the bytes transferred, the rate and the time left,
+// adding up transfers that run at the same time; otherwise it prints a line
+// per transfer every few seconds.
+type ProgressBar struct {
+	out         *os.File
+	interactive bool
+	mu          sync.Mutex
+	// active are the unfinished transfers
+	active    []*barTransfer
+	lastDrawn time.Time
+	// drawn is whether the bar is on screen
+	drawn bool
+}
+
+func NewProgressBar(out *os.File) *ProgressBar {
+	return &ProgressBar{
+		out:         out,
+		interactive: isTerminal(out),
+	}
+}
+
+// isTerminal reports whether f is a character device, i.e. a terminal
+// rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *ProgressBar) Track(description string, total int64) services.Transfer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	transfer := &barTransfer{
+		bar:         p,
+		description: description,
+		total:       total,
+		start:       now,
+		lastDrawn:   now,
+	}
+	p.active = append(p.active, transfer)
+	return transfer
+}
+
+// barTransfer is a transfer drawn by a ProgressBar.
+type barTransfer struct {
+	bar         *ProgressBar
+	description string
+	total       int64
+	done        int64
+	start       time.Time
+	// lastDrawn and drawn are only used when printing lines
+	lastDrawn time.Time
+	drawn     bool
+}
+
+func (t *barTransfer) Add(n int64) {
+	p := t.bar
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t.done += n
+
+	now := time.Now()
+	if now.Sub(t.start) < progressDelay {
+		return
+	}
+	if !p.interactive {
+		if now.Sub(t.lastDrawn) >= lineInterval {
+			p.printLine(now, t)
+		}
+		return
+	}
+	if now.Sub(p.lastDrawn) >= barRedraw {
+		p.drawBar(now, p.active)
+	}
+}
+
+func (t *barTransfer) Finish() {
+	p := t.bar
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if i := slices.Index(p.active, t); i >= 0 {
+		p.active = slices.Delete(p.active, i, i+1)
+	}
+
+	// Transfers too quick to be shown aren't mentioned at all
+	now := time.Now()
+	if !p.interactive {
+		if t.drawn {
+			p.printLine(now, t)
+		}
+		return
+	}
+	if !p.drawn {
+		return
+	}
+	if len(p.active) > 0 {
+		p.drawBar(now, p.active)
+		return
+	}
+	p.drawBar(now, []*barTransfer{t})
+	fmt.Fprintln(p.out)
+	p.drawn = false
+}
+
+// printLine prints the progress of a transfer on a line of its own.
+func (p *ProgressBar) printLine(now time.Time, t *barTransfer) {
+	t.lastDrawn = now
+	t.drawn = true
+	progress := measure(now, []*barTransfer{t})
+
+	line := progress.description + ":"
+	if progress.percent != "" {
+		line += " " + strings.TrimSpace(progress.percent)
+	}
+	details := []string{progress.amount, progress.rate}
+	if progress.eta != "" {
+		details = append(details, progress.eta)
+	}
+	fmt.Fprintf(p.out, "%s %s\n", line, strings.Join(details, ", "))
+}
+
+// drawBar redraws the bar with the combined progress of transfers.
+func (p *ProgressBar) drawBar(now time.Time, transfers []*barTransfer) {
+	p.lastDrawn = now
+	p.drawn = true
+	progress := measure(now, transfers)
+
+	bar := strings.Repeat(" ", barWidth)
+	if progress.total > 0 {
+		filled := int(min(progress.done*barWidth/progress.total, barWidth))
+		bar = strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+	}
+	// \033[K clears what's left of a longer previous line
+	fmt.Fprintf(p.out, "\r%s [%s] %s %s  %s  %s\033[K", progress.description, bar, progress.percent, progress.amount, progress.rate, progress.eta)
+}
+
+// progress is the combined progress of transfers, formatted for display.
+type progress struct {
+	description string
+	done, total int64
+	amount      string
+	percent     string
+	rate        string
+	eta         string
+}
+
+// measure works out the combined progress of transfers. The rate is
+// measured from the start of the earliest one.
+func measure(now time.Time, transfers []*barTransfer) progress {
+	result := progress{description: transfers[0].description}
+	if len(transfers) > 1 {
+		result.description = fmt.Sprintf("%d transfers", len(transfers))
+	}
+	start := now
+	unknownTotal := false
+	for _, t := range transfers {
+		result.done += t.done
+		result.total += t.total
+		unknownTotal = unknownTotal || t.total == 0
+		if t.start.Before(start) {
+			start = t.start
+		}
+	}
+	if unknownTotal {
+		result.total = 0
+	}
+
+	rate := int64(0)
+	if elapsed := now.Sub(start).Seconds(); elapsed > 0 {
+		rate = int64(float64(result.done) / elapsed)
+	}
+	result.rate = utils.GetSizeField(rate) + "/s"
+	result.amount = utils.GetSizeField(result.done)
+	if result.total > 0 {
+		result.amount += " of " + utils.GetSizeField(result.total)
+		result.percent = fmt.Sprintf("%3d%%", min(result.done*100/result.total, 100))
+		if rate > 0 && result.done < result.total {
+			result.eta = "ETA " + formatETA(time.Duration(float64(result.total-result.done)/float64(rate)*float64(time.Second)))
+		}
+	}
+	return result
+}
+
+// formatETA formats the time left like 1:05:09 or 5:09.
+func formatETA(d time.Duration) string {
+	seconds := int64(d.Round(time.Second).Seconds())
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
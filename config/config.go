@@ -0,0 +1,113 @@
+package config
+
+import (
+	"filevault/utils"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Config holds the settings FileVault reads from the environment at startup.
+// Every setting has a default so the CLI works without any configuration.
+type Config struct {
+	// StorageBackend selects where blobs are kept: "local" or "s3"
+	StorageBackend string
+	// LocalStoragePath is the directory used by the local backend
+	LocalStoragePath string
+	// QuarantinePath is where vault fsck --repair moves content nothing
+	// references
+	QuarantinePath string
+
+	// Settings for the S3-compatible backend (AWS S3, MinIO, ...)
+	S3Endpoint  string
+	S3Bucket    string
+	S3Region    string
+	S3AccessKey string
+	S3SecretKey string
+
+	// MaxVersions is how many versions of a file are kept. 0 keeps them all
+	MaxVersions int
+	// TrashRetentionDays is how long deleted files stay in the trash
+	TrashRetentionDays int
+
+	// DefaultQuota is the storage quota in bytes of users without an
+	// override. 0 means unlimited
+	DefaultQuota int64
+	// Admins are the emails of users allowed to change quotas and repair
+	// the vault. When set, only they may check it, otherwise any user can
+	Admins []string
+
+	// IndexContent enables the full-text index of text files searched by
+	// vault grep. The index keeps their text unencrypted in filevault.db,
+	// readable by anyone with the database, so it's off by default
+	IndexContent bool
+	// Compress enables compressing text-heavy uploads before they're
+	// encrypted and stored
+	Compress bool
+}
+
+// Load reads the configuration from FILEVAULT_* environment variables.
+func Load() *Config {
+	return &Config{
+		StorageBackend:     getEnv("FILEVAULT_STORAGE", "local"),
+		LocalStoragePath:   getEnv("FILEVAULT_STORAGE_PATH", "./storage/uploads"),
+		QuarantinePath:     getEnv("FILEVAULT_QUARANTINE_PATH", "./storage/quarantine"),
+		S3Endpoint:         getEnv("FILEVAULT_S3_ENDPOINT", "http://localhost:9000"),
+		S3Bucket:           getEnv("FILEVAULT_S3_BUCKET", "filevault"),
+		S3Region:           getEnv("FILEVAULT_S3_REGION", "us-east-1"),
+		S3AccessKey:        getEnv("FILEVAULT_S3_ACCESS_KEY", ""),
+		S3SecretKey:        getEnv("FILEVAULT_S3_SECRET_KEY", ""),
+		MaxVersions:        getEnvInt("FILEVAULT_MAX_VERSIONS", 10),
+		TrashRetentionDays: getEnvInt("FILEVAULT_TRASH_RETENTION_DAYS", 30),
+		DefaultQuota:       getEnvSize("FILEVAULT_DEFAULT_QUOTA", 1<<30),
+		Admins:             getEnvList("FILEVAULT_ADMINS"),
+		IndexContent:       getEnvBool("FILEVAULT_INDEX_CONTENT", false),
+		Compress:           getEnvBool("FILEVAULT_COMPRESS", true),
+	}
+}
+
+// getEnv returns the value of an environment variable or a fallback when unset.
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok && value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getEnvInt is getEnv for integer settings. Invalid values use the fallback.
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(getEnv(key, strconv.Itoa(fallback)))
+	if err != nil || value < 0 {
+		return fallback
+	}
+	return value
+}
+
+// getEnvBool is getEnv for on/off settings. Invalid values use the fallback.
+func getEnvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(getEnv(key, strconv.FormatBool(fallback)))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// getEnvSize is getEnv for sizes like "500MB". Invalid values use the fallback.
+func getEnvSize(key string, fallback int64) int64 {
+	value, err := utils.ParseSize(getEnv(key, strconv.FormatInt(fallback, 10)))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// getEnvList is getEnv for comma separated lists.
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
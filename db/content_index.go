@@ -0,0 +1,41 @@
+package db
+
+import "database/sql"
+
+// The full-text index of file contents is an FTS5 table. FTS5 is only
+// compiled into builds using the sqlite_fts5 tag, and the index can always
+// be rebuilt from the stored files, so it isn't part of the migrations. It's
+// created whenever the running build supports it instead.
+
+// ContentIndexAvailable reports whether the content_index table exists and
+// this build of SQLite has FTS5 to query it.
+func ContentIndexAvailable(conn *sql.DB) bool {
+	if !fts5Available(conn) {
+		return false
+	}
+	var count int
+	err := conn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'content_index'").Scan(&count)
+	return err == nil && count > 0
+}
+
+// fts5Available reports whether this build of SQLite has FTS5.
+func fts5Available(conn *sql.DB) bool {
+	var used bool
+	err := conn.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&used)
+	return err == nil && used
+}
+
+// ensureContentIndex creates the content_index table when FTS5 is available.
+func ensureContentIndex(conn *sql.DB) error {
+	if !fts5Available(conn) {
+		return nil
+	}
+	_, err := conn.Exec(`
+        CREATE VIRTUAL TABLE IF NOT EXISTS content_index USING fts5(
+            file_id UNINDEXED,
+            content,
+            tokenize = 'porter unicode61'
+        );
+    `)
+	return err
+}
@@ -0,0 +1,439 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration is one step in the evolution of the database schema.
+// Migrations run in order, each in its own transaction, and are recorded in
+// the schema_version table once applied. Never edit a released migration,
+// append a new one instead.
+type migration struct {
+	version     int
+	description string
+	up          func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{1, "Initial schema", migrateInitialSchema},
+	{2, "Store file sizes as integers", migrateIntegerFileSizes},
+	{3, "Add file grants", migrateFileGrants},
+	{4, "Add file tags and attributes", migrateFileTags},
+	{5, "Add folders", migrateFolders},
+	{6, "Record MIME types", migrateMimeTypes},
+	{7, "Add upload sessions", migrateUploadSessions},
+	{8, "Record compression codecs", migrateCodecs},
+}
+
+// AppliedMigration is a migration recorded in the schema_version table.
+type AppliedMigration struct {
+	Version     int
+	Description string
+	AppliedAt   time.Time
+}
+
+// SchemaStatus describes the version of the schema.
+type SchemaStatus struct {
+	Current int
+	Latest  int
+	Applied []AppliedMigration
+	Pending []string
+}
+
+// Migrate applies every migration newer than the current schema version.
+func Migrate(conn *sql.DB) error {
+	_, err := conn.Exec(`
+        CREATE TABLE IF NOT EXISTS schema_version (
+            version INTEGER PRIMARY KEY,
+            description TEXT,
+            applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+        );
+    `)
+	if err != nil {
+		return err
+	}
+
+	current, err := currentVersion(conn)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(conn, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.description, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs a migration and records it in a single transaction, so
+// a failing migration leaves the schema as it was.
+func applyMigration(conn *sql.DB, m migration) error {
+	tx, err := conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO schema_version (version, description, applied_at) VALUES (?, ?, ?)", m.version, m.description, time.Now())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func currentVersion(conn *sql.DB) (int, error) {
+	var version int
+	err := conn.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+// Status reports the current schema version and the migrations applied so far.
+func Status(conn *sql.DB) (*SchemaStatus, error) {
+	current, err := currentVersion(conn)
+	if err != nil {
+		return nil, err
+	}
+	status := &SchemaStatus{
+		Current: current,
+		Latest:  migrations[len(migrations)-1].version,
+	}
+
+	rows, err := conn.Query("SELECT version, description, applied_at FROM schema_version ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var applied AppliedMigration
+		if err := rows.Scan(&applied.Version, &applied.Description, &applied.AppliedAt); err != nil {
+			return nil, err
+		}
+		status.Applied = append(status.Applied, applied)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, m := range migrations {
+		if m.version > current {
+			status.Pending = append(status.Pending, fmt.Sprintf("%d - %s", m.version, m.description))
+		}
+	}
+	return status, nil
+}
+
+// migrateInitialSchema creates the schema as it was before migrations
+// existed. Databases created by those builds may already have some of it,
+// possibly without columns added later, so every step is idempotent.
+func migrateInitialSchema(tx *sql.Tx) error {
+	// Create users table
+	_, err := tx.Exec(`
+        CREATE TABLE IF NOT EXISTS users (
+            id TEXT PRIMARY KEY,
+            email TEXT UNIQUE,
+            password TEXT,
+            key_salt TEXT,
+            wrapped_key TEXT,
+            quota_bytes INTEGER
+        );
+    `)
+	if err != nil {
+		return err
+	}
+
+	// Create files table
+	_, err = tx.Exec(`
+       CREATE TABLE IF NOT EXISTS files (
+            id TEXT PRIMARY KEY,
+            user_id TEXT,
+            file_name TEXT,
+            file_path TEXT,
+            size TEXT,
+            checksum TEXT,
+            encrypted INTEGER NOT NULL DEFAULT 0,
+            uploaded_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            deleted_at DATETIME,
+            FOREIGN KEY (user_id) REFERENCES users(id)
+        );
+    `)
+	if err != nil {
+		return err
+	}
+
+	// Create blobs table, one row per distinct stored content
+	_, err = tx.Exec(`
+        CREATE TABLE IF NOT EXISTS blobs (
+            hash TEXT PRIMARY KEY,
+            size INTEGER,
+            ref_count INTEGER NOT NULL DEFAULT 0
+        );
+    `)
+	if err != nil {
+		return err
+	}
+
+	// Create file_versions table, one row per upload of a file
+	_, err = tx.Exec(`
+        CREATE TABLE IF NOT EXISTS file_versions (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            file_id TEXT,
+            version INTEGER,
+            size INTEGER,
+            file_path TEXT,
+            checksum TEXT,
+            encrypted INTEGER NOT NULL DEFAULT 0,
+            uploaded_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE (file_id, version),
+            FOREIGN KEY (file_id) REFERENCES files(id)
+        );
+    `)
+	if err != nil {
+		return err
+	}
+
+	// Columns older builds added to existing tables one by one
+	columns := []struct{ table, column, definition string }{
+		{"files", "checksum", "TEXT"},
+		{"files", "encrypted", "INTEGER NOT NULL DEFAULT 0"},
+		{"files", "deleted_at", "DATETIME"},
+		{"users", "key_salt", "TEXT"},
+		{"users", "wrapped_key", "TEXT"},
+		{"users", "quota_bytes", "INTEGER"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(tx, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	// Files uploaded before versioning become version 1 of themselves
+	_, err = tx.Exec(`
+        INSERT INTO file_versions (file_id, version, size, file_path, checksum, encrypted, uploaded_at)
+        SELECT id, 1, size, file_path, checksum, encrypted, uploaded_at FROM files
+        WHERE id NOT IN (SELECT file_id FROM file_versions);
+    `)
+	return err
+}
+
+// migrateIntegerFileSizes changes files.size from TEXT to INTEGER. SQLite
+// can't change the type of a column, so the table is rebuilt.
+func migrateIntegerFileSizes(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        CREATE TABLE files_new (
+            id TEXT PRIMARY KEY,
+            user_id TEXT,
+            file_name TEXT,
+            file_path TEXT,
+            size INTEGER NOT NULL DEFAULT 0,
+            checksum TEXT,
+            encrypted INTEGER NOT NULL DEFAULT 0,
+            uploaded_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            deleted_at DATETIME,
+            FOREIGN KEY (user_id) REFERENCES users(id)
+        );
+    `)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+        INSERT INTO files_new (id, user_id, file_name, file_path, size, checksum, encrypted, uploaded_at, deleted_at)
+        SELECT id, user_id, file_name, file_path, CAST(COALESCE(size, 0) AS INTEGER), checksum, encrypted, uploaded_at, deleted_at
+        FROM files;
+    `)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DROP TABLE files"); err != nil {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE files_new RENAME TO files")
+	return err
+}
+
+// migrateFileGrants adds the key pairs content keys are shared with and the
+// grants giving users access to files they don't own.
+func migrateFileGrants(tx *sql.Tx) error {
+	if _, err := tx.Exec("ALTER TABLE users ADD COLUMN public_key TEXT"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("ALTER TABLE users ADD COLUMN wrapped_private_key TEXT"); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+        CREATE TABLE file_grants (
+            file_id TEXT NOT NULL,
+            user_id TEXT NOT NULL,
+            permission TEXT NOT NULL,
+            wrapped_key TEXT,
+            key_checksum TEXT,
+            granted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (file_id, user_id),
+            FOREIGN KEY (file_id) REFERENCES files(id),
+            FOREIGN KEY (user_id) REFERENCES users(id)
+        );
+    `)
+	return err
+}
+
+// migrateFileTags adds the tags and key/value attributes files are labelled
+// with.
+func migrateFileTags(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        CREATE TABLE file_tags (
+            file_id TEXT NOT NULL,
+            tag TEXT NOT NULL,
+            PRIMARY KEY (file_id, tag),
+            FOREIGN KEY (file_id) REFERENCES files(id)
+        );
+    `)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("CREATE INDEX idx_file_tags_tag ON file_tags (tag)")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+        CREATE TABLE file_attributes (
+            file_id TEXT NOT NULL,
+            key TEXT NOT NULL,
+            value TEXT NOT NULL,
+            PRIMARY KEY (file_id, key),
+            FOREIGN KEY (file_id) REFERENCES files(id)
+        );
+    `)
+	return err
+}
+
+// migrateFolders adds the folders files are organized in. Files and folders
+// without a parent are in the root folder.
+func migrateFolders(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        CREATE TABLE folders (
+            id TEXT PRIMARY KEY,
+            user_id TEXT NOT NULL,
+            parent_id TEXT,
+            name TEXT NOT NULL,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            FOREIGN KEY (user_id) REFERENCES users(id),
+            FOREIGN KEY (parent_id) REFERENCES folders(id)
+        );
+    `)
+	if err != nil {
+		return err
+	}
+	// Names are unique within a folder, the root included
+	_, err = tx.Exec("CREATE UNIQUE INDEX idx_folders_name ON folders (user_id, COALESCE(parent_id, ''), name)")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("ALTER TABLE files ADD COLUMN folder_id TEXT REFERENCES folders(id)")
+	return err
+}
+
+// migrateMimeTypes adds the MIME type sniffed from the content of files and
+// their versions.
+func migrateMimeTypes(tx *sql.Tx) error {
+	if _, err := tx.Exec("ALTER TABLE files ADD COLUMN mime_type TEXT"); err != nil {
+		return err
+	}
+	_, err := tx.Exec("ALTER TABLE file_versions ADD COLUMN mime_type TEXT")
+	return err
+}
+
+// addColumnIfMissing adds a column to a table created by an older build.
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			rows.Close()
+			return err
+		}
+		if name == column {
+			found = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if found {
+		return nil
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// migrateUploadSessions adds the tables tracking large uploads stored in
+// parts, so an interrupted upload can continue where it stopped.
+func migrateUploadSessions(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+        CREATE TABLE upload_sessions (
+            id TEXT PRIMARY KEY,
+            user_id TEXT NOT NULL,
+            folder_id TEXT,
+            file_name TEXT NOT NULL,
+            source_path TEXT NOT NULL,
+            size INTEGER NOT NULL,
+            modified_at DATETIME NOT NULL,
+            checksum TEXT NOT NULL,
+            blob_key TEXT NOT NULL,
+            created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+            FOREIGN KEY (user_id) REFERENCES users(id),
+            FOREIGN KEY (folder_id) REFERENCES folders(id)
+        );
+    `)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+        CREATE TABLE upload_parts (
+            session_id TEXT NOT NULL,
+            part INTEGER NOT NULL,
+            size INTEGER NOT NULL,
+            hash TEXT NOT NULL,
+            PRIMARY KEY (session_id, part),
+            FOREIGN KEY (session_id) REFERENCES upload_sessions(id)
+        );
+    `)
+	return err
+}
+
+// migrateCodecs records the codec content is compressed with before it's
+// encrypted, empty for content stored as is. Upload sessions of compressed
+// content also keep the size and digest of the compressed stream their parts
+// are cut from.
+func migrateCodecs(tx *sql.Tx) error {
+	for _, table := range []string{"files", "file_versions", "upload_sessions"} {
+		if _, err := tx.Exec("ALTER TABLE " + table + " ADD COLUMN codec TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("ALTER TABLE upload_sessions ADD COLUMN compressed_size INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	_, err := tx.Exec("ALTER TABLE upload_sessions ADD COLUMN compressed_checksum TEXT NOT NULL DEFAULT ''")
+	return err
+}
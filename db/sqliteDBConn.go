@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -11,31 +12,11 @@ func GetSQLiteDBConn() (*sql.DB, error) {
 		return nil, err
 	}
 
-	// Create users table
-	_, err = conn.Exec(`
-        CREATE TABLE IF NOT EXISTS users (
-            id TEXT PRIMARY KEY,
-            email TEXT UNIQUE,
-            password TEXT
-        );
-    `)
-	if err != nil {
+	// Bring the schema up to date
+	if err := Migrate(conn); err != nil {
 		return nil, err
 	}
-
-	// Create files table
-	_, err = conn.Exec(`
-       CREATE TABLE IF NOT EXISTS files (
-            id TEXT PRIMARY KEY,
-            user_id TEXT,
-            file_name TEXT,
-            file_path TEXT,
-            size TEXT,
-            uploaded_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-            FOREIGN KEY (user_id) REFERENCES users(id)
-        );
-    `)
-	if err != nil {
+	if err := ensureContentIndex(conn); err != nil {
 		return nil, err
 	}
 
@@ -2,12 +2,15 @@ module filevault
 
 go 1.24.4
 
-require github.com/google/uuid v1.6.0
+require (
+	github.com/google/uuid v1.6.0
+	github.com/mattn/go-sqlite3 v1.14.28
+	github.com/redis/go-redis/v9 v9.11.0
+	golang.org/x/crypto v0.39.0
+)
 
 require (
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
-	github.com/mattn/go-sqlite3 v1.14.28 // indirect
-	github.com/redis/go-redis/v9 v9.11.0 // indirect
-	golang.org/x/crypto v0.39.0 // indirect
+	golang.org/x/sys v0.33.0 // indirect
 )
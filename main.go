@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"filevault/cli"
+	"filevault/config"
 	"filevault/db"
 	"filevault/services"
 	"fmt"
@@ -18,6 +19,8 @@ func init() {
 func main() {
 	// Get user input
 	scanner := bufio.NewScanner(os.Stdin)
+	// Settings come from FILEVAULT_* environment variables
+	cfg := config.Load()
 	// Connect to backing stores
 	// SQLite3 backing store
 	dbConn, err := db.GetSQLiteDBConn()
@@ -31,9 +34,26 @@ func main() {
 		fmt.Printf("Error connecting to Redis: %v\n", err)
 		return
 	}
-	fileService := services.NewFileService(dbConn, redisClient)
+	// Blob storage backend, selected through FILEVAULT_STORAGE
+	blobStore, err := services.NewBlobStore(cfg)
+	if err != nil {
+		fmt.Printf("Error setting up blob storage: %v\n", err)
+		return
+	}
+	fileService := services.NewFileService(dbConn, redisClient, blobStore, cfg)
+	// Show the progress of uploads and downloads
+	fileService.SetProgressReporter(cli.NewProgressBar(os.Stdout))
 	authService := services.NewAuthService(dbConn, redisClient)
-	cm := cli.NewCommandRouter(fileService, authService)
+	// Permanently delete files that have been in the trash for too long
+	if err := fileService.PurgeExpiredTrash(); err != nil {
+		fmt.Printf("Error purging the trash: %v\n", err)
+	}
+	// Drop the parts of uploads that were never resumed
+	if err := fileService.PurgeStaleUploads(); err != nil {
+		fmt.Printf("Error removing interrupted uploads: %v\n", err)
+	}
+	dbService := services.NewDBService(dbConn)
+	cm := cli.NewCommandRouter(fileService, authService, dbService)
 
 	for {
 		// Prompt for input
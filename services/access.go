@@ -0,0 +1,252 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Every operation on a single file goes through authorize, which resolves
+// the caller once and checks they either own the file or have been granted
+// a permission covering the operation. Owners can grant "read" access,
+// which allows downloading a file and viewing its details, or "write"
+// access, which also allows renaming and deleting it. Versions and sharing
+// stay with the owner.
+//
+// Grants carry the file's content key sealed to the grantee's public key,
+// so they can decrypt it without ever seeing the owner's data key. The
+// sealed key is replaced whenever the file gets new content.
+
+var (
+	ErrForbidden         = errors.New("Forbidden: you don't have permission to do that with this file")
+	ErrInvalidPermission = errors.New("Permission must be read or write")
+	ErrNoSharingKey      = errors.New("This user has to log in once before files can be shared with them")
+	ErrNotShared         = errors.New("File isn't shared with this user")
+	ErrShareWithSelf     = errors.New("You can't share a file with yourself")
+)
+
+// Permissions a file can be shared with
+const (
+	PermissionRead  = "read"
+	PermissionWrite = "write"
+)
+
+// fileAction is an operation on a file that needs authorization.
+type fileAction int
+
+const (
+	actionRead   fileAction = iota // download, info
+	actionWrite                    // rename
+	actionDelete                   // move to the trash
+	actionManage                   // versions and sharing, owners only
+)
+
+// grantedActions lists what each permission allows.
+var grantedActions = map[string][]fileAction{
+	PermissionRead:  {actionRead},
+	PermissionWrite: {actionRead, actionWrite, actionDelete},
+}
+
+// fileAccess is an authorized caller's view of a live file.
+type fileAccess struct {
+	fileId    string
+	userId    string
+	ownerId   string
+	fileName  string
+	size      int64
+	filePath  string
+	checksum  string
+	encrypted bool
+	codec     string
+	// permission is the grant the caller has, empty for the owner
+	permission string
+	// wrappedKey is the content key sealed for a grantee and keyChecksum the
+	// content it belongs to
+	wrappedKey  string
+	keyChecksum string
+}
+
+func (a *fileAccess) isOwner() bool {
+	return a.permission == ""
+}
+
+// authorize checks that the logged in user may perform action on a file
+// that isn't in the trash.
+func (s *FileService) authorize(fileId string, action fileAction) (*fileAccess, error) {
+	userId, err := s.currentUserID()
+	if err != nil {
+		return nil, err
+	}
+	if fileId == "" {
+		return nil, errors.New("file ID is missing")
+	}
+
+	access := &fileAccess{fileId: fileId, userId: userId}
+	var ownerId, filePath, checksum, permission, wrappedKey, keyChecksum sql.NullString
+	query := `SELECT f.user_id, f.file_name, f.size, f.file_path, f.checksum, f.encrypted, f.codec, g.permission, g.wrapped_key, g.key_checksum
+        FROM files f LEFT JOIN file_grants g ON g.file_id = f.id AND g.user_id = ?
+        WHERE f.id = ? AND f.deleted_at IS NULL`
+	err = s.db.QueryRow(query, userId, fileId).
+		Scan(&ownerId, &access.fileName, &access.size, &filePath, &checksum, &access.encrypted, &access.codec, &permission, &wrappedKey, &keyChecksum)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrFileNotExistent
+		}
+		return nil, fmt.Errorf("failed to query file: %w", err)
+	}
+	access.ownerId = ownerId.String
+	access.filePath = filePath.String
+	access.checksum = checksum.String
+
+	if ownerId.Valid && ownerId.String == userId {
+		return access, nil
+	}
+	if permission.Valid && slices.Contains(grantedActions[permission.String], action) {
+		access.permission = permission.String
+		access.wrappedKey = wrappedKey.String
+		access.keyChecksum = keyChecksum.String
+		return access, nil
+	}
+	return nil, ErrForbidden
+}
+
+// contentKeyFor returns the key the caller decrypts the current content of
+// a file with.
+func (s *FileService) contentKeyFor(access *fileAccess) ([]byte, error) {
+	dataKey, err := s.dataKey()
+	if err != nil {
+		return nil, err
+	}
+	if access.isOwner() {
+		return contentKey(dataKey, access.checksum), nil
+	}
+
+	if access.wrappedKey == "" || access.keyChecksum != access.checksum {
+		return nil, errors.New("the shared key of this file is out of date, ask its owner to share it again")
+	}
+	var wrappedPrivateKey sql.NullString
+	if err := s.db.QueryRow("SELECT wrapped_private_key FROM users WHERE id = ?", access.userId).Scan(&wrappedPrivateKey); err != nil {
+		return nil, fmt.Errorf("failed to query sharing keys: %w", err)
+	}
+	return openFromSender(dataKey, wrappedPrivateKey.String, access.wrappedKey)
+}
+
+// ShareFile grants the user with the given email access to a file.
+// Sharing a file again replaces the permission it was shared with.
+func (s *FileService) ShareFile(fileId, email, permission string) error {
+	access, err := s.authorize(fileId, actionManage)
+	if err != nil {
+		return err
+	}
+	if _, ok := grantedActions[permission]; !ok {
+		return ErrInvalidPermission
+	}
+
+	var granteeId string
+	var publicKey sql.NullString
+	err = s.db.QueryRow("SELECT id, public_key FROM users WHERE email = ?", email).Scan(&granteeId, &publicKey)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		}
+		return fmt.Errorf("failed to query user: %w", err)
+	}
+	if granteeId == access.userId {
+		return ErrShareWithSelf
+	}
+	if !publicKey.Valid {
+		return ErrNoSharingKey
+	}
+
+	var wrappedKey sql.NullString
+	if access.encrypted {
+		dataKey, err := s.dataKey()
+		if err != nil {
+			return err
+		}
+		sealed, err := sealForRecipient(publicKey.String, contentKey(dataKey, access.checksum))
+		if err != nil {
+			return fmt.Errorf("failed to seal content key: %w", err)
+		}
+		wrappedKey = sql.NullString{String: sealed, Valid: true}
+	}
+
+	_, err = s.db.Exec(`INSERT INTO file_grants (file_id, user_id, permission, wrapped_key, key_checksum, granted_at) VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT (file_id, user_id) DO UPDATE SET permission = excluded.permission, wrapped_key = excluded.wrapped_key,
+        key_checksum = excluded.key_checksum, granted_at = excluded.granted_at`,
+		fileId, granteeId, permission, wrappedKey, access.checksum, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to share file: %w", err)
+	}
+	fmt.Printf("Shared %s with %s (%s access)\n", access.fileName, email, permission)
+	return nil
+}
+
+// UnshareFile revokes the access a user was granted to a file.
+func (s *FileService) UnshareFile(fileId, email string) error {
+	access, err := s.authorize(fileId, actionManage)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec("DELETE FROM file_grants WHERE file_id = ? AND user_id = (SELECT id FROM users WHERE email = ?)", fileId, email)
+	if err != nil {
+		return fmt.Errorf("failed to unshare file: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrNotShared
+	}
+	fmt.Printf("%s no longer has access to %s\n", email, access.fileName)
+	return nil
+}
+
+// resealGrants seals the new content key of a file for everyone it's shared
+// with. It runs in the transaction changing the file's content.
+func (s *FileService) resealGrants(tx *sql.Tx, fileId, checksum string, encrypted bool) error {
+	rows, err := tx.Query("SELECT g.user_id, u.public_key FROM file_grants g JOIN users u ON u.id = g.user_id WHERE g.file_id = ?", fileId)
+	if err != nil {
+		return err
+	}
+	var grantees [][2]string
+	for rows.Next() {
+		var userId string
+		var publicKey sql.NullString
+		if err := rows.Scan(&userId, &publicKey); err != nil {
+			rows.Close()
+			return err
+		}
+		grantees = append(grantees, [2]string{userId, publicKey.String})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(grantees) == 0 {
+		return nil
+	}
+
+	var dataKey []byte
+	if encrypted {
+		if dataKey, err = s.dataKey(); err != nil {
+			return err
+		}
+	}
+	for _, grantee := range grantees {
+		var wrappedKey sql.NullString
+		if encrypted {
+			sealed, err := sealForRecipient(grantee[1], contentKey(dataKey, checksum))
+			if err != nil {
+				return err
+			}
+			wrappedKey = sql.NullString{String: sealed, Valid: true}
+		}
+		_, err := tx.Exec("UPDATE file_grants SET wrapped_key = ?, key_checksum = ? WHERE file_id = ? AND user_id = ?",
+			wrappedKey, checksum, fileId, grantee[0])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,7 +1,6 @@
 package services
 
 import (
-	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"errors"
@@ -14,7 +13,6 @@ import (
 
 	"github.com/mattn/go-sqlite3"
 	"github.com/redis/go-redis/v9"
-	"golang.org/x/crypto/pbkdf2"
 )
 
 var (
@@ -53,13 +51,30 @@ func (s *AuthService) Register(email, password string) error {
 		return ErrUserAlreadyExists
 	}
 
-	// Hash password (with PBKDF2)
-	hash := pbkdf2.Key([]byte(password), []byte("salt"), 1000, 32, sha256.New)
-	hashedPassword := hex.EncodeToString(hash)
+	// Hash password (with Argon2id and a salt of its own)
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
 	userID := utils.GenerateRandomString(16)
+	// Generate the key the user's files are encrypted with and wrap it with
+	// a key derived from their password
+	dataKey, keySalt, err := newDataKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate data key: %w", err)
+	}
+	wrappedKey, err := wrapDataKey(dataKey, password, keySalt)
+	if err != nil {
+		return fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	// Generate the key pair files are shared with
+	publicKey, wrappedPrivateKey, err := newSharingKeys(dataKey)
+	if err != nil {
+		return fmt.Errorf("failed to generate sharing keys: %w", err)
+	}
 	// Store user in the database 
-	query := "INSERT INTO users (id, email, password) VALUES (?, ?, ?)"
-	_, err = s.conn.ExecContext(context.Background(), query, userID, email, hashedPassword)
+	query := "INSERT INTO users (id, email, password, key_salt, wrapped_key, public_key, wrapped_private_key) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	_, err = s.conn.ExecContext(context.Background(), query, userID, email, hashedPassword, hex.EncodeToString(keySalt), wrappedKey, publicKey, wrappedPrivateKey)
 	if err != nil {
 		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
 			return ErrUserAlreadyExists
@@ -82,9 +97,10 @@ func (s *AuthService) Login(email, password string) error {
 		}
 
 		// Check if user exists in the database
-		var hashedPassword string
-		query := "SELECT password FROM users WHERE email = ?"
-		err := s.conn.QueryRowContext(context.Background(), query, email).Scan(&hashedPassword)
+		var userID, hashedPassword string
+		var keySalt, wrappedKey, publicKey sql.NullString
+		query := "SELECT id, password, key_salt, wrapped_key, public_key FROM users WHERE email = ?"
+		err := s.conn.QueryRowContext(context.Background(), query, email).Scan(&userID, &hashedPassword, &keySalt, &wrappedKey, &publicKey)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				return fmt.Errorf("user not found: %w", err)
@@ -92,10 +108,29 @@ func (s *AuthService) Login(email, password string) error {
 			return fmt.Errorf("failed to query user: %w", err)
 		}
 		// Verify password
-		hash := pbkdf2.Key([]byte(password), []byte("salt"), 1000, 32, sha256.New)
-		hashedInputPassword := hex.EncodeToString(hash)
-		if hashedInputPassword != hashedPassword {
-			return fmt.Errorf("invalid password")
+		ok, rehash, err := verifyPassword(password, hashedPassword)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidPassword
+		}
+		// Legacy and outdated hashes are replaced now the password is known
+		if rehash {
+			if err := s.rehashPassword(userID, password); err != nil {
+				fmt.Printf("Warning: failed to upgrade your password hash: %v\n", err)
+			}
+		}
+		// Unlock the key the user's files are encrypted with
+		dataKey, err := s.unlockDataKey(userID, password, keySalt.String, wrappedKey.String)
+		if err != nil {
+			return err
+		}
+		// Users registered before sharing existed get their key pair now
+		if !publicKey.Valid {
+			if err := s.createSharingKeys(userID, dataKey); err != nil {
+				return err
+			}
 		}
 		// If password matches, create a session
 		// Check if session already exists
@@ -115,6 +150,11 @@ func (s *AuthService) Login(email, password string) error {
 		if err != nil {
 			return fmt.Errorf("failed to create session: %w", err)
 		}
+		// Keep the unwrapped data key for as long as the session lives
+		err = s.client.Set(context.Background(), dataKeyCacheKey(sessionID), hex.EncodeToString(dataKey), 15 * time.Minute).Err()
+		if err != nil {
+			return fmt.Errorf("failed to store data key: %w", err)
+		}
 		// Store this "state" that the token represents in a file
 		file, err := os.Create("./vault_session")
 		if err != nil {
@@ -141,6 +181,9 @@ func (s *AuthService) Logout() error {
 	}
 	sessionID := string(sessionIDBytes)
 	delCount, err := s.client.Del(context.Background(), sessionID).Result()
+	if err == nil {
+		err = s.client.Del(context.Background(), dataKeyCacheKey(sessionID)).Err()
+	}
 	if err != nil {
 		return fmt.Errorf("failed to delete session from Redis: %w", err)
 	}
@@ -160,4 +203,57 @@ func (s *AuthService) Logout() error {
 	}
 	fmt.Println("Logged out successfully.")
 	return nil
-}
\ No newline at end of file
+}
+
+// unlockDataKey unwraps a user's data key with their password. Users
+// registered before files were encrypted don't have one yet, so it's
+// generated and stored here, the first time their password is known.
+func (s *AuthService) unlockDataKey(userID, password, encodedSalt, wrappedKey string) ([]byte, error) {
+	if wrappedKey != "" {
+		keySalt, err := hex.DecodeString(encodedSalt)
+		if err != nil {
+			return nil, ErrInvalidDataKey
+		}
+		return unwrapDataKey(wrappedKey, password, keySalt)
+	}
+
+	dataKey, keySalt, err := newDataKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	wrappedKey, err = wrapDataKey(dataKey, password, keySalt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	query := "UPDATE users SET key_salt = ?, wrapped_key = ? WHERE id = ?"
+	_, err = s.conn.ExecContext(context.Background(), query, hex.EncodeToString(keySalt), wrappedKey, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// rehashPassword replaces the stored hash of a user's password with one
+// made the current way.
+func (s *AuthService) rehashPassword(userID, password string) error {
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.ExecContext(context.Background(), "UPDATE users SET password = ? WHERE id = ?", hashedPassword, userID)
+	return err
+}
+
+// createSharingKeys stores a new key pair for a user who doesn't have one.
+func (s *AuthService) createSharingKeys(userID string, dataKey []byte) error {
+	publicKey, wrappedPrivateKey, err := newSharingKeys(dataKey)
+	if err != nil {
+		return fmt.Errorf("failed to generate sharing keys: %w", err)
+	}
+	query := "UPDATE users SET public_key = ?, wrapped_private_key = ? WHERE id = ?"
+	_, err = s.conn.ExecContext(context.Background(), query, publicKey, wrappedPrivateKey, userID)
+	if err != nil {
+		return fmt.Errorf("failed to store sharing keys: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var ErrUploadsFailed = errors.New("Some files failed to upload")
+
+// defaultUploadWorkers is how many files uploadAll uploads at the same time
+// unless told otherwise.
+const defaultUploadWorkers = 4
+
+// uploadJob is a file for uploadAll to upload, and the folder it goes to.
+type uploadJob struct {
+	path   string
+	folder string
+}
+
+// uploadResult is the outcome of one of the uploads of uploadAll.
+type uploadResult struct {
+	path string
+	// uploaded is the version stored, unless the upload failed with err
+	uploaded *uploadedVersion
+	err      error
+}
+
+// UploadFiles uploads several files to a folder, up to workers of them at
+// the same time (see uploadAll). Paths may be glob patterns like *.pdf,
+// matching directories are skipped. A summary of which files were stored
+// and which failed is printed at the end; an upload that fails doesn't stop
+// the others.
+func (s *FileService) UploadFiles(patterns []string, folder string, workers int) error {
+	if len(patterns) == 0 {
+		return ErrMissingPathname
+	}
+	paths, results := expandUploadPatterns(patterns)
+
+	// A single file is uploaded as usual
+	if len(paths) == 1 && len(results) == 0 {
+		return s.UploadFile(paths[0], folder)
+	}
+
+	jobs := make([]uploadJob, len(paths))
+	for i, path := range paths {
+		jobs[i] = uploadJob{path: path, folder: folder}
+	}
+	results = append(results, s.uploadAll(jobs, workers)...)
+
+	failed := 0
+	fmt.Println("\nUpload summary:")
+	for _, result := range results {
+		if result.err != nil {
+			failed++
+			fmt.Printf("  failed  %s: %v\n", result.path, result.err)
+			continue
+		}
+		fmt.Printf("  ok      %s: version %d of file %s\n", result.path, result.uploaded.version, result.uploaded.fileId)
+	}
+	fmt.Printf("%d of %d file(s) uploaded\n", len(results)-failed, len(results))
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d", ErrUploadsFailed, failed, len(results))
+	}
+	return nil
+}
+
+// uploadAll uploads files with a pool of workers, up to workers of them at
+// the same time (defaultUploadWorkers when workers is 0). It returns the
+// result of each upload in the order of jobs, for the caller to print once
+// they're all done.
+func (s *FileService) uploadAll(jobs []uploadJob, workers int) []uploadResult {
+	// After Ctrl+C the running uploads are rolled back and the queued ones
+	// aren't started
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if workers <= 0 {
+		workers = defaultUploadWorkers
+	}
+	results := make([]uploadResult, len(jobs))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for range min(workers, len(jobs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i].path = jobs[i].path
+				if ctx.Err() != nil {
+					results[i].err = ErrUploadInterrupted
+					continue
+				}
+				results[i].uploaded, results[i].err = s.upload(jobs[i].path, jobs[i].folder, false)
+			}
+		}()
+	}
+	for i := range jobs {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	return results
+}
+
+// expandUploadPatterns expands the glob patterns among the paths to upload,
+// dropping paths named more than once. Patterns that match no file are
+// returned as failed uploads.
+func expandUploadPatterns(patterns []string) ([]string, []uploadResult) {
+	var paths []string
+	var failed []uploadResult
+	seen := map[string]bool{}
+	add := func(path string) {
+		key, err := filepath.Abs(path)
+		if err != nil {
+			key = path
+		}
+		if !seen[key] {
+			seen[key] = true
+			paths = append(paths, path)
+		}
+	}
+
+	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, "*?[") {
+			add(pattern)
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			failed = append(failed, uploadResult{path: pattern, err: err})
+			continue
+		}
+		files := 0
+		for _, match := range matches {
+			if stat, err := os.Stat(match); err == nil && stat.IsDir() {
+				continue
+			}
+			add(match)
+			files++
+		}
+		if files == 0 {
+			failed = append(failed, uploadResult{path: pattern, err: errors.New("no files match")})
+		}
+	}
+	return paths, failed
+}
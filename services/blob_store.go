@@ -0,0 +1,92 @@
+package services
+
+import (
+	"errors"
+	"filevault/config"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrUnknownStorageBackend = errors.New("Unknown storage backend")
+)
+
+// BlobStore is the storage backend FileService keeps file content in.
+// Blobs are addressed by key and are immutable once written.
+type BlobStore interface {
+	// Put stores size bytes read from r under key, replacing any existing blob.
+	Put(key string, r io.Reader, size int64) error
+	// Get opens the blob stored under key. A missing blob yields an error
+	// matching os.ErrNotExist.
+	Get(key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key. Deleting a missing blob is
+	// not an error.
+	Delete(key string) error
+	// Exists reports whether a blob is stored under key.
+	Exists(key string) (bool, error)
+	// Compose stores the concatenation of the blobs under parts, size bytes
+	// in total, under key. The parts are left as they are.
+	Compose(key string, parts []string, size int64) error
+	// List calls fn with the key and size of every stored blob, stopping at
+	// the first error fn returns.
+	List(fn func(key string, size int64) error) error
+}
+
+// NewBlobStore returns the backend selected by the configuration.
+func NewBlobStore(cfg *config.Config) (BlobStore, error) {
+	switch cfg.StorageBackend {
+	case "local":
+		return NewLocalBlobStore(cfg.LocalStoragePath), nil
+	case "s3":
+		return NewS3BlobStore(cfg.S3Endpoint, cfg.S3Bucket, cfg.S3Region, cfg.S3AccessKey, cfg.S3SecretKey)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownStorageBackend, cfg.StorageBackend)
+	}
+}
+
+// composeByCopy implements Compose by streaming the parts into a new blob.
+func composeByCopy(store BlobStore, key string, parts []string, size int64) error {
+	content := &partsReader{store: store, parts: parts}
+	defer content.Close()
+	return store.Put(key, content, size)
+}
+
+// partsReader reads a list of blobs one after the other, opening each only
+// once the previous one is read.
+type partsReader struct {
+	store   BlobStore
+	parts   []string
+	current io.ReadCloser
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.parts) == 0 {
+				return 0, io.EOF
+			}
+			part, err := r.store.Get(r.parts[0])
+			if err != nil {
+				return 0, err
+			}
+			r.current, r.parts = part, r.parts[1:]
+		}
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *partsReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	return r.current.Close()
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Blobs are stored once per distinct content under a digest. Files uploaded
+// before encryption existed use the SHA-256 of their content, encrypted ones
+// a key derived from it (see encryption.go). Several file records may point
+// at the same blob; the blobs table keeps a reference count so the bytes are
+// only removed when the last one goes away.
+
+// writtenBlob describes content stored by writeBlob.
+type writtenBlob struct {
+	// checksum and size are the digest and size of the plaintext
+	checksum string
+	size     int64
+	// key is what the blob is stored under and storedSize its size there
+	key        string
+	storedSize int64
+	// codec is what the content was compressed with before it was
+	// encrypted, empty when it wasn't
+	codec string
+	// created is whether the blob was written by this call
+	created bool
+}
+
+// writeBlob encrypts r into the blob store with a key derived from the
+// user's data key. Content that is already stored isn't written a second
+// time. With compress, content that gets smaller when compressed is stored
+// compressed, see compression.go. The passes over r are reported as
+// transfers of name, expected to be expectedSize bytes long. With an upload
+// session the content is stored in parts, see upload_sessions.go.
+func (s *FileService) writeBlob(r io.ReadSeeker, name string, expectedSize int64, dataKey []byte, compress bool, session *uploadSession) (*writtenBlob, error) {
+	// Hash the content first as the keys are derived from its digest
+	hasher := sha256.New()
+	transfer := s.track("Hashing "+name, expectedSize)
+	size, err := io.Copy(hasher, progressReader{Reader: r, transfer: transfer})
+	transfer.Finish()
+	if err != nil {
+		return nil, err
+	}
+	blob := &writtenBlob{checksum: hex.EncodeToString(hasher.Sum(nil)), size: size}
+	blob.key = encryptedBlobKey(dataKey, blob.checksum)
+
+	exists, err := s.store.Exists(blob.key)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		// Same content is already stored. How it was stored is only known
+		// from the versions using it, content nothing uses is written again
+		err := s.db.QueryRow("SELECT v.codec, b.size FROM file_versions v JOIN blobs b ON b.hash = v.file_path WHERE v.file_path = ? LIMIT 1", blob.key).
+			Scan(&blob.codec, &blob.storedSize)
+		if err == nil {
+			return blob, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, fmt.Errorf("failed to query blob: %w", err)
+		}
+	}
+
+	// Compressed content is spooled to a temporary file first, as its size
+	// has to be known before it's stored
+	content, length := r, size
+	compressedChecksum := ""
+	if compress {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		compressed, compressedSize, checksum, err := s.compressContent(r, name, size)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress content: %w", err)
+		}
+		defer os.Remove(compressed.Name())
+		defer compressed.Close()
+		if compressedSize < size {
+			content, length, compressedChecksum = compressed, compressedSize, checksum
+			blob.codec = codecGzip
+			// The copy stops on Ctrl+C like the original does
+			if interruptible, ok := r.(*interruptibleReader); ok {
+				content = &interruptibleReader{ctx: interruptible.ctx, ReadSeeker: compressed}
+			}
+		}
+	}
+	blob.storedSize = encryptedSize(length)
+
+	if session != nil {
+		if err := s.saveUploadSession(session, blob, length, compressedChecksum); err != nil {
+			return nil, err
+		}
+		if err := s.putParts(session, content, dataKey); err != nil {
+			return nil, err
+		}
+		blob.created = true
+		return blob, nil
+	}
+
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	transfer = s.track("Uploading "+name, length)
+	defer transfer.Finish()
+	encrypted, err := newEncryptReader(contentKey(dataKey, blob.checksum), progressReader{Reader: content, transfer: transfer})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.Put(blob.key, encrypted, blob.storedSize); err != nil {
+		return nil, err
+	}
+	blob.created = true
+	return blob, nil
+}
+
+// removeUnrecordedBlob removes the blob of an upload that failed, unless an
+// upload of the same content running at the same time recorded it.
+func (s *FileService) removeUnrecordedBlob(blobKey string) {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	var refCount int
+	err := s.db.QueryRow("SELECT ref_count FROM blobs WHERE hash = ?", blobKey).Scan(&refCount)
+	if err != sql.ErrNoRows {
+		return
+	}
+	if err := s.store.Delete(blobKey); err != nil {
+		fmt.Printf("Warning: failed to remove content of the failed upload: %v\n", err)
+	}
+}
+
+// retainBlob records one more reference to a blob.
+func retainBlob(tx *sql.Tx, hash string, size int64) error {
+	_, err := tx.Exec(`
+		INSERT INTO blobs (hash, size, ref_count) VALUES (?, ?, 1)
+		ON CONFLICT(hash) DO UPDATE SET ref_count = ref_count + 1
+	`, hash, size)
+	return err
+}
+
+// releaseBlob drops a reference to a blob. It reports whether that was the
+// last reference, in which case the caller should remove the blob once the
+// transaction commits.
+func releaseBlob(tx *sql.Tx, hash string) (bool, error) {
+	var refCount int
+	err := tx.QueryRow("SELECT ref_count FROM blobs WHERE hash = ?", hash).Scan(&refCount)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if refCount > 1 {
+		_, err = tx.Exec("UPDATE blobs SET ref_count = ref_count - 1 WHERE hash = ?", hash)
+		return false, err
+	}
+	_, err = tx.Exec("DELETE FROM blobs WHERE hash = ?", hash)
+	return true, err
+}
+
+// rowQueryer is implemented by both *sql.DB and *sql.Tx.
+type rowQueryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// storedBlobKey returns the blob key of a file record. Records written since
+// encryption was added keep the key in file_path, older ones are stored under
+// their checksum. An empty key means the file predates blobs and still lives
+// at its own path on disk.
+func storedBlobKey(db rowQueryer, filePath, checksum string) (string, error) {
+	for _, key := range []string{filePath, checksum} {
+		var count int
+		err := db.QueryRow("SELECT COUNT(*) FROM blobs WHERE hash = ?", key).Scan(&count)
+		if err != nil {
+			return "", err
+		}
+		if count > 0 {
+			return key, nil
+		}
+	}
+	return "", nil
+}
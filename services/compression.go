@@ -0,0 +1,131 @@
+package services
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Text-heavy content like logs and CSVs is compressed with gzip before it's
+// encrypted, since encrypted bytes don't compress. Which uploads are worth
+// it is decided from their MIME type and extension, and content that
+// doesn't get smaller is stored as is. The codec is recorded with the file
+// and its versions, and content is decompressed again as it's read.
+
+var ErrUnknownCodec = errors.New("Content is compressed with an unknown codec")
+
+const (
+	// codecGzip is the codec of gzip compressed content. Content stored as
+	// is has no codec
+	codecGzip = "gzip"
+	// minCompressSize is the smallest content worth compressing
+	minCompressSize = 1 << 10
+)
+
+// incompressibleExtensions are formats that are compressed already, so
+// compressing them again only costs time.
+var incompressibleExtensions = map[string]bool{
+	".gz": true, ".tgz": true, ".zip": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true, ".rar": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".avif": true,
+	".mp3": true, ".aac": true, ".ogg": true, ".flac": true, ".mp4": true, ".mkv": true, ".mov": true, ".webm": true,
+	".pdf": true, ".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".epub": true, ".jar": true, ".apk": true,
+}
+
+// compressibleExtensions are text formats sniffing may not recognize.
+var compressibleExtensions = map[string]bool{
+	".txt": true, ".log": true, ".csv": true, ".tsv": true, ".json": true, ".ndjson": true, ".xml": true,
+	".html": true, ".htm": true, ".css": true, ".js": true, ".md": true, ".sql": true, ".yaml": true, ".yml": true,
+	".toml": true, ".ini": true, ".svg": true, ".go": true, ".py": true, ".java": true, ".c": true, ".h": true,
+}
+
+// compressibleTypes are MIME types besides text/* worth compressing.
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/javascript": true,
+	"application/x-ndjson":   true,
+	"application/sql":        true,
+	"image/svg+xml":          true,
+}
+
+// compressible reports whether content with a name and sniffed MIME type is
+// worth compressing.
+func compressible(name, mimeType string, size int64) bool {
+	if size < minCompressSize {
+		return false
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if incompressibleExtensions[ext] {
+		return false
+	}
+	mediaType, _, _ := strings.Cut(mimeType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType] || compressibleExtensions[ext]
+}
+
+// compressContent compresses r into a temporary file, reporting progress as
+// a transfer of name. It returns the file rewound, with the size and digest
+// of the compressed content. The caller removes the file. The output only
+// depends on the input, so compressing the same content again for a resumed
+// upload gives the same bytes.
+func (s *FileService) compressContent(r io.Reader, name string, size int64) (*os.File, int64, string, error) {
+	temp, err := os.CreateTemp("", ".vault-compress-*")
+	if err != nil {
+		return nil, 0, "", err
+	}
+	fail := func(err error) (*os.File, int64, string, error) {
+		temp.Close()
+		os.Remove(temp.Name())
+		return nil, 0, "", err
+	}
+
+	hasher := sha256.New()
+	counter := &countingWriter{Writer: io.MultiWriter(temp, hasher)}
+	compressor := gzip.NewWriter(counter)
+	transfer := s.track("Compressing "+name, size)
+	_, err = io.Copy(compressor, progressReader{Reader: r, transfer: transfer})
+	transfer.Finish()
+	if err != nil {
+		return fail(err)
+	}
+	if err := compressor.Close(); err != nil {
+		return fail(err)
+	}
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	return temp, counter.n, hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// newDecompressReader undoes the compression of content stored with codec.
+func newDecompressReader(codec string, r io.Reader) (io.Reader, error) {
+	switch codec {
+	case "":
+		return r, nil
+	case codecGzip:
+		decompressed, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress content: %w", err)
+		}
+		return decompressed, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, codec)
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.n += int64(n)
+	return n, err
+}
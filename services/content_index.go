@@ -0,0 +1,253 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"filevault/db"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Text files are indexed in the content_index FTS5 table as they're
+// uploaded, so vault grep can search inside them. Only the first
+// maxIndexedText bytes of a file are indexed.
+//
+// The index holds the extracted text unencrypted in the database, so it's
+// off unless FILEVAULT_INDEX_CONTENT=true, and the table only exists in
+// builds using the sqlite_fts5 tag, which make build sets.
+
+var (
+	ErrContentIndexUnavailable = errors.New("Content search isn't available in this build, it has no FTS5 index. Build FileVault with make build, or go build -tags sqlite_fts5")
+	ErrContentIndexDisabled    = errors.New("Content indexing is turned off. Set FILEVAULT_INDEX_CONTENT=true to enable it, then run vault grep --reindex to index files uploaded so far")
+)
+
+const maxIndexedText = 10 << 20
+
+// textExtensions are the extensions of files indexed without sniffing.
+var textExtensions = []string{
+	".txt", ".text", ".md", ".markdown", ".rst", ".log", ".csv", ".tsv",
+	".json", ".yaml", ".yml", ".toml", ".ini", ".conf", ".cfg", ".xml",
+	".html", ".htm", ".tex",
+}
+
+// highlight markers put around matches in grep snippets
+const (
+	highlightStart = "\033[1;33m"
+	highlightEnd   = "\033[0m"
+)
+
+// contentIndexEnabled reports whether uploads are indexed.
+func (s *FileService) contentIndexEnabled() bool {
+	return s.config.IndexContent && db.ContentIndexAvailable(s.db)
+}
+
+// extractText returns the text of a file worth indexing. Files are indexed
+// when their extension is a known text format, or their content sniffs as
+// text, and they're valid UTF-8.
+func extractText(fileName string, r io.Reader) (string, bool, error) {
+	content, err := io.ReadAll(io.LimitReader(r, maxIndexedText))
+	if err != nil {
+		return "", false, err
+	}
+	if len(content) == 0 {
+		return "", false, nil
+	}
+
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if !slices.Contains(textExtensions, ext) && !strings.HasPrefix(http.DetectContentType(content), "text/") {
+		return "", false, nil
+	}
+	// Truncation may have cut a character in half
+	if len(content) == maxIndexedText {
+		for i := 0; i < utf8.UTFMax && len(content) > 0 && !utf8.Valid(content); i++ {
+			content = content[:len(content)-1]
+		}
+	}
+	if !utf8.Valid(content) {
+		return "", false, nil
+	}
+	return string(content), true, nil
+}
+
+// indexContent replaces the indexed text of a file with the text read from r.
+func (s *FileService) indexContent(tx *sql.Tx, fileId, fileName string, r io.Reader) error {
+	if !s.contentIndexEnabled() {
+		return nil
+	}
+	if _, err := tx.Exec("DELETE FROM content_index WHERE file_id = ?", fileId); err != nil {
+		return err
+	}
+	text, ok, err := extractText(fileName, r)
+	if err != nil || !ok {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO content_index (file_id, content) VALUES (?, ?)", fileId, text)
+	return err
+}
+
+// removeFromIndex drops the indexed text of a file.
+func (s *FileService) removeFromIndex(tx *sql.Tx, fileId string) error {
+	if !db.ContentIndexAvailable(s.db) {
+		return nil
+	}
+	_, err := tx.Exec("DELETE FROM content_index WHERE file_id = ?", fileId)
+	return err
+}
+
+// reindexFile indexes the current content of a file the user owns.
+func (s *FileService) reindexFile(fileId, fileName, filePath, checksum string, encrypted bool, codec string) error {
+	var key []byte
+	if encrypted {
+		dataKey, err := s.dataKey()
+		if err != nil {
+			return err
+		}
+		key = contentKey(dataKey, checksum)
+	}
+	content, err := s.openStoredFile(filePath, checksum, encrypted, codec, key)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := s.indexContent(tx, fileId, fileName, content); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReindexContent rebuilds the index of the user's files, e.g. for files
+// uploaded while indexing was off.
+func (s *FileService) ReindexContent() error {
+	userId, err := s.currentUserID()
+	if err != nil {
+		return err
+	}
+	if err := s.checkContentIndex(); err != nil {
+		return err
+	}
+
+	rows, err := s.db.Query("SELECT id, file_name, file_path, checksum, encrypted, codec FROM files WHERE user_id = ? AND deleted_at IS NULL", userId)
+	if err != nil {
+		return fmt.Errorf("failed to query files: %w", err)
+	}
+	type storedFile struct {
+		id, name, path, checksum string
+		encrypted                bool
+		codec                    string
+	}
+	var files []storedFile
+	for rows.Next() {
+		var file storedFile
+		var checksum sql.NullString
+		if err := rows.Scan(&file.id, &file.name, &file.path, &checksum, &file.encrypted, &file.codec); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+		file.checksum = checksum.String
+		files = append(files, file)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read files: %w", err)
+	}
+
+	// Text of files that no longer exist is dropped on the way
+	if _, err := s.db.Exec("DELETE FROM content_index WHERE file_id NOT IN (SELECT id FROM files)"); err != nil {
+		return fmt.Errorf("failed to clean the index: %w", err)
+	}
+	for _, file := range files {
+		if err := s.reindexFile(file.id, file.name, file.path, file.checksum, file.encrypted, file.codec); err != nil {
+			fmt.Printf("Warning: failed to index %s: %v\n", file.name, err)
+		}
+	}
+	fmt.Printf("Reindexed %d file(s)\n", len(files))
+	return nil
+}
+
+// Grep prints the user's files whose content matches all the terms, best
+// matches first, with a snippet of the matching text.
+func (s *FileService) Grep(terms []string, limit int) error {
+	userId, err := s.currentUserID()
+	if err != nil {
+		return err
+	}
+	if err := s.checkContentIndex(); err != nil {
+		return err
+	}
+	if len(terms) == 0 {
+		return errors.New("no search terms given")
+	}
+	if limit == 0 {
+		limit = 20
+	}
+
+	query := `SELECT f.id, f.file_name, snippet(content_index, 1, ?, ?, '...', 12)
+        FROM content_index JOIN files f ON f.id = content_index.file_id
+        WHERE content_index MATCH ? AND f.user_id = ? AND f.deleted_at IS NULL
+        ORDER BY rank LIMIT ?`
+	rows, err := s.db.Query(query, highlightStart, highlightEnd, matchExpression(terms), userId, limit)
+	if err != nil {
+		return fmt.Errorf("failed to search contents: %w", err)
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var fileId, fileName, snippet string
+		if err := rows.Scan(&fileId, &fileName, &snippet); err != nil {
+			return fmt.Errorf("failed to read match: %w", err)
+		}
+		fmt.Printf("%s  %s\n    %s\n", fileId, fileName, strings.Join(strings.Fields(snippet), " "))
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read matches: %w", err)
+	}
+	if count == 0 {
+		fmt.Println("No files match")
+	}
+	return nil
+}
+
+// checkContentIndex explains why content can't be searched, if it can't,
+// naming every step left to enable it.
+func (s *FileService) checkContentIndex() error {
+	available, enabled := db.ContentIndexAvailable(s.db), s.config.IndexContent
+	switch {
+	case !available && !enabled:
+		return fmt.Errorf("%w. %w", ErrContentIndexUnavailable, ErrContentIndexDisabled)
+	case !available:
+		return ErrContentIndexUnavailable
+	case !enabled:
+		return ErrContentIndexDisabled
+	}
+	return nil
+}
+
+// matchExpression builds an FTS5 query matching all the terms. Terms are
+// quoted so they're never parsed as FTS5 syntax, except for a trailing *
+// which still matches by prefix.
+func matchExpression(terms []string) string {
+	quoted := make([]string, 0, len(terms))
+	for _, term := range terms {
+		prefix := strings.HasSuffix(term, "*") && len(term) > 1
+		term = strings.TrimSuffix(term, "*")
+		term = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+		if prefix {
+			term += "*"
+		}
+		quoted = append(quoted, term)
+	}
+	return strings.Join(quoted, " ")
+}
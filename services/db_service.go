@@ -0,0 +1,37 @@
+package services
+
+import (
+	"database/sql"
+	"filevault/db"
+	"fmt"
+	"time"
+)
+
+type DBService struct {
+	conn *sql.DB
+}
+
+func NewDBService(conn *sql.DB) *DBService {
+	return &DBService{
+		conn: conn,
+	}
+}
+
+// Status prints the schema version of the database and its migrations.
+func (s *DBService) Status() error {
+	status, err := db.Status(s.conn)
+	if err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	fmt.Printf("Schema version: %d (latest %d)\n", status.Current, status.Latest)
+	fmt.Println("Version | Applied At          | Description")
+	fmt.Println("--------+---------------------+------------------------------")
+	for _, applied := range status.Applied {
+		fmt.Printf("%-7d | %-19s | %s\n", applied.Version, applied.AppliedAt.Local().Format(time.DateTime), applied.Description)
+	}
+	for _, pending := range status.Pending {
+		fmt.Printf("Pending: %s\n", pending)
+	}
+	return nil
+}
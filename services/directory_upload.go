@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"filevault/utils"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var ErrNotADirectory = errors.New("Not a directory")
+
+// vaultIgnoreFile names the file at the top of a directory listing what
+// UploadDirectory leaves out. Every line is a glob pattern, like in a
+// .gitignore: a pattern ending in / only matches directories, one with a /
+// elsewhere matches paths relative to the directory, and any other matches
+// names at every depth. Blank lines and lines starting with # are skipped.
+const vaultIgnoreFile = ".vaultignore"
+
+// ignoreRule is a pattern of a .vaultignore file.
+type ignoreRule struct {
+	pattern string
+	// dirOnly rules only match directories
+	dirOnly bool
+	// anchored rules match the path relative to the uploaded directory
+	// rather than the name
+	anchored bool
+}
+
+// loadIgnoreRules reads the .vaultignore file of a directory, if it has one.
+func loadIgnoreRules(dir string) ([]ignoreRule, error) {
+	file, err := os.Open(filepath.Join(dir, vaultIgnoreFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		pattern := strings.TrimSpace(scanner.Text())
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		rule := ignoreRule{}
+		rule.dirOnly = strings.HasSuffix(pattern, "/")
+		pattern = strings.TrimSuffix(pattern, "/")
+		rule.anchored = strings.Contains(pattern, "/")
+		rule.pattern = strings.TrimPrefix(pattern, "/")
+		if _, err := path.Match(rule.pattern, ""); err != nil {
+			return nil, fmt.Errorf("%s line %d: invalid pattern %q", vaultIgnoreFile, line, scanner.Text())
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// ignored reports whether the entry at rel, a slash separated path relative
+// to the uploaded directory, matches one of the rules.
+func ignored(rules []ignoreRule, rel string, isDir bool) bool {
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		target := path.Base(rel)
+		if rule.anchored {
+			target = rel
+		}
+		if matched, _ := path.Match(rule.pattern, target); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// UploadDirectory uploads a directory tree into a folder of the vault, the
+// current folder when empty. The directory becomes a folder of the same
+// name with the structure below it recreated, reusing folders that already
+// exist. Entries matching its .vaultignore, links and other special files
+// are skipped. Files are uploaded like UploadFiles does, and the version
+// each one was stored as and the totals are printed at the end.
+func (s *FileService) UploadDirectory(dir, folder string, workers int) error {
+	userId, err := s.currentUserID()
+	if err != nil {
+		return err
+	}
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return fmt.Errorf("%w: %s", ErrNotADirectory, dir)
+	}
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if !validFolderName(filepath.Base(root)) {
+		return ErrInvalidFolderName
+	}
+	parentId, err := s.resolveFolder(userId, folder)
+	if err != nil {
+		return err
+	}
+	rules, err := loadIgnoreRules(root)
+	if err != nil {
+		return err
+	}
+
+	// Recreate the folders while walking the tree, and queue the files
+	folderIds := map[string]string{}
+	folderPaths := map[string]string{}
+	var jobs []uploadJob
+	var sizes []int64
+	var failures []uploadResult
+	created, skipped, special := 0, 0, 0
+	err = filepath.WalkDir(root, func(pathname string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if pathname == root {
+				return err
+			}
+			failures = append(failures, uploadResult{path: pathname, err: err})
+			return nil
+		}
+		rel, err := filepath.Rel(root, pathname)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if entry.IsDir() {
+			parent, name := parentId, filepath.Base(root)
+			if rel != "." {
+				if ignored(rules, rel, true) {
+					skipped++
+					return fs.SkipDir
+				}
+				parent, name = folderIds[path.Dir(rel)], entry.Name()
+			}
+			folderId, err := s.childFolder(userId, parent, name)
+			if err == sql.ErrNoRows {
+				if folderId, err = s.createFolder(userId, parent, name); err != nil {
+					return err
+				}
+				created++
+			} else if err != nil {
+				return fmt.Errorf("failed to query folder: %w", err)
+			}
+			folderIds[rel] = folderId
+			if folderPaths[rel], err = s.folderPath(folderId); err != nil {
+				return err
+			}
+			return nil
+		}
+
+		switch {
+		case rel == vaultIgnoreFile:
+			return nil
+		case ignored(rules, rel, false):
+			skipped++
+			return nil
+		case !entry.Type().IsRegular():
+			special++
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			failures = append(failures, uploadResult{path: pathname, err: err})
+			return nil
+		}
+		jobs = append(jobs, uploadJob{path: pathname, folder: folderPaths[path.Dir(rel)]})
+		sizes = append(sizes, info.Size())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	uploaded, bytes := 0, int64(0)
+	results := s.uploadAll(jobs, workers)
+	for i, result := range results {
+		if result.err != nil {
+			failures = append(failures, result)
+			continue
+		}
+		uploaded++
+		bytes += sizes[i]
+	}
+
+	fmt.Println()
+	for _, result := range results {
+		if result.err != nil {
+			continue
+		}
+		name := result.path
+		if rel, err := filepath.Rel(filepath.Dir(root), result.path); err == nil {
+			name = rel
+		}
+		fmt.Printf("  ok      %s: version %d of file %s\n", name, result.uploaded.version, result.uploaded.fileId)
+	}
+	for _, failure := range failures {
+		fmt.Printf("  failed  %s: %v\n", failure.path, failure.err)
+	}
+	fmt.Printf("Uploaded %d of %d file(s), %s, to %s\n", uploaded, len(jobs), utils.GetSizeField(bytes), folderPaths["."])
+	fmt.Printf("Created %d folder(s), skipped %d ignored entries and %d links or special files\n", created, skipped, special)
+	if len(failures) > 0 {
+		return fmt.Errorf("%w: %d failed", ErrUploadsFailed, len(failures))
+	}
+	return nil
+}
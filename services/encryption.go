@@ -0,0 +1,393 @@
+package services
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// File content is encrypted at rest with AES-256-GCM in fixed size chunks.
+//
+// Every user has a random data key (DEK) which is stored wrapped by a key
+// derived from their password, so it can only be unwrapped at login. Each
+// file is encrypted with its own key derived from the DEK and the digest of
+// its content. Identical content of the same user therefore encrypts to the
+// same blob and is still deduplicated, while nothing in the blob store
+// reveals the content or its digest.
+//
+// Encrypted blobs start with a short header followed by the sealed chunks.
+// Chunk nonces are a counter plus a flag marking the final chunk, so chunks
+// can't be reordered, dropped or truncated without failing authentication.
+
+var (
+	ErrVaultLocked      = errors.New("Your files are locked. Log in again to unlock them")
+	ErrInvalidDataKey   = errors.New("Failed to unwrap data key")
+	ErrCorruptEncrypted = errors.New("Encrypted content is corrupt or has been tampered with")
+)
+
+const (
+	encryptionMagic     = "FVE1"
+	encryptionChunkSize = 64 * 1024
+	gcmTagSize          = 16
+	dataKeySize         = 32
+
+	// Argon2id parameters for deriving the key that wraps a user's DEK
+	keyDerivationTime    = 1
+	keyDerivationMemory  = 64 * 1024
+	keyDerivationThreads = 4
+)
+
+// newDataKey generates a user's random data key and the salt used to derive
+// the key wrapping it.
+func newDataKey() (dataKey, keySalt []byte, err error) {
+	dataKey = make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	keySalt = make([]byte, 16)
+	if _, err := rand.Read(keySalt); err != nil {
+		return nil, nil, err
+	}
+	return dataKey, keySalt, nil
+}
+
+// passwordKey derives the key that wraps a user's data key.
+func passwordKey(password string, keySalt []byte) []byte {
+	return argon2.IDKey([]byte(password), keySalt, keyDerivationTime, keyDerivationMemory, keyDerivationThreads, dataKeySize)
+}
+
+// wrapDataKey encrypts a data key with the key derived from the password.
+// The result is hex encoded for storage in the users table.
+func wrapDataKey(dataKey []byte, password string, keySalt []byte) (string, error) {
+	return sealKey(passwordKey(password, keySalt), dataKey)
+}
+
+// unwrapDataKey reverses wrapDataKey.
+func unwrapDataKey(wrappedKey string, password string, keySalt []byte) ([]byte, error) {
+	return openKey(passwordKey(password, keySalt), wrappedKey)
+}
+
+// sealKey encrypts a key with another key, hex encoding the result.
+func sealKey(wrappingKey, key []byte) (string, error) {
+	aead, err := newGCM(wrappingKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(aead.Seal(nonce, nonce, key, nil)), nil
+}
+
+// openKey reverses sealKey.
+func openKey(wrappingKey []byte, sealedKey string) ([]byte, error) {
+	sealed, err := hex.DecodeString(sealedKey)
+	if err != nil {
+		return nil, ErrInvalidDataKey
+	}
+	aead, err := newGCM(wrappingKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrInvalidDataKey
+	}
+	key, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrInvalidDataKey
+	}
+	return key, nil
+}
+
+// Content keys are shared with other users by sealing them to the X25519
+// public key of the recipient. Every user has a key pair whose private key
+// is stored sealed with their data key, so only they can open what's been
+// shared with them.
+
+// newSharingKeys generates a user's key pair, returning the hex encoded
+// public key and the private key sealed with their data key.
+func newSharingKeys(dataKey []byte) (publicKey, wrappedPrivateKey string, err error) {
+	privateKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	wrappedPrivateKey, err = sealKey(dataKey, privateKey.Bytes())
+	if err != nil {
+		return "", "", err
+	}
+	return hex.EncodeToString(privateKey.PublicKey().Bytes()), wrappedPrivateKey, nil
+}
+
+// sealForRecipient encrypts a key so only the owner of publicKey can open it.
+// A fresh ephemeral key pair is agreed with the recipient's for every key.
+func sealForRecipient(publicKey string, key []byte) (string, error) {
+	recipientBytes, err := hex.DecodeString(publicKey)
+	if err != nil {
+		return "", ErrInvalidDataKey
+	}
+	recipient, err := ecdh.X25519().NewPublicKey(recipientBytes)
+	if err != nil {
+		return "", ErrInvalidDataKey
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return "", err
+	}
+	ephemeralBytes := ephemeral.PublicKey().Bytes()
+	sealed, err := sealKey(sharedKey(shared, ephemeralBytes, recipientBytes), key)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(ephemeralBytes) + sealed, nil
+}
+
+// openFromSender reverses sealForRecipient with the recipient's private key,
+// unwrapped with their data key.
+func openFromSender(dataKey []byte, wrappedPrivateKey, sealedKey string) ([]byte, error) {
+	privateBytes, err := openKey(dataKey, wrappedPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, err := ecdh.X25519().NewPrivateKey(privateBytes)
+	if err != nil {
+		return nil, ErrInvalidDataKey
+	}
+	encodedSize := 2 * len(privateKey.PublicKey().Bytes())
+	if len(sealedKey) < encodedSize {
+		return nil, ErrInvalidDataKey
+	}
+	ephemeralBytes, err := hex.DecodeString(sealedKey[:encodedSize])
+	if err != nil {
+		return nil, ErrInvalidDataKey
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(ephemeralBytes)
+	if err != nil {
+		return nil, ErrInvalidDataKey
+	}
+	shared, err := privateKey.ECDH(ephemeral)
+	if err != nil {
+		return nil, ErrInvalidDataKey
+	}
+	return openKey(sharedKey(shared, ephemeralBytes, privateKey.PublicKey().Bytes()), sealedKey[encodedSize:])
+}
+
+// sharedKey derives the key sealing a shared key from an X25519 agreement,
+// bound to both public keys involved.
+func sharedKey(shared, ephemeralPublic, recipientPublic []byte) []byte {
+	hash := sha256.New()
+	hash.Write([]byte("share:"))
+	hash.Write(shared)
+	hash.Write(ephemeralPublic)
+	hash.Write(recipientPublic)
+	return hash.Sum(nil)
+}
+
+// dataKeyCacheKey is the Redis key holding the unwrapped data key of a session.
+func dataKeyCacheKey(sessionID string) string {
+	return sessionID + ":data_key"
+}
+
+// contentKey derives the key a file's content is encrypted with.
+func contentKey(dataKey []byte, checksum string) []byte {
+	mac := hmac.New(sha256.New, dataKey)
+	mac.Write([]byte("content:" + checksum))
+	return mac.Sum(nil)
+}
+
+// encryptedBlobKey derives the blob store key of encrypted content, so the
+// store never sees the digest of the plaintext.
+func encryptedBlobKey(dataKey []byte, checksum string) string {
+	mac := hmac.New(sha256.New, dataKey)
+	mac.Write([]byte("blob:" + checksum))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// encryptedSize returns the size of plaintextSize bytes once encrypted.
+func encryptedSize(plaintextSize int64) int64 {
+	chunks := (plaintextSize + encryptionChunkSize - 1) / encryptionChunkSize
+	if chunks == 0 {
+		// Empty content still gets a final chunk
+		chunks = 1
+	}
+	return int64(len(encryptionMagic)) + plaintextSize + chunks*gcmTagSize
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// chunkNonce builds the nonce of a chunk from its index and whether it's the
+// final chunk of the stream.
+func chunkNonce(index uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, index)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// encryptReader encrypts the plaintext read from src as it's being read.
+type encryptReader struct {
+	aead   cipher.AEAD
+	src    *bufio.Reader
+	chunk  []byte
+	sealed []byte
+	out    []byte
+	index  uint64
+	header bool
+	// final is whether the end of src is the end of the stream, false for
+	// all but the last part of content encrypted in parts
+	final bool
+	done  bool
+}
+
+func newEncryptReader(key []byte, src io.Reader) (io.Reader, error) {
+	return newPartEncryptReader(key, src, 0, true)
+}
+
+// newPartEncryptReader encrypts one part of a stream, starting at the chunk
+// with the given index. Chunks are sealed independently with nonces derived
+// from their index, so parts encrypted separately concatenate to the same
+// bytes newEncryptReader produces. Parts other than the last must be a
+// multiple of encryptionChunkSize long.
+func newPartEncryptReader(key []byte, src io.Reader, index uint64, final bool) (io.Reader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptReader{
+		aead:  aead,
+		src:   bufio.NewReader(src),
+		chunk: make([]byte, encryptionChunkSize),
+		index: index,
+		// Only the first part starts with the header
+		header: index != 0,
+		final:  final,
+	}, nil
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if !r.header {
+			r.header = true
+			r.out = []byte(encryptionMagic)
+			continue
+		}
+
+		n, err := io.ReadFull(r.src, r.chunk)
+		last := false
+		switch {
+		case err == io.EOF && !r.final:
+			// The part ended with its previous chunk, the next part goes on
+			r.done = true
+			continue
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			last = r.final
+		case err != nil:
+			return 0, err
+		default:
+			// A full chunk is the last one when nothing follows it
+			if _, peekErr := r.src.Peek(1); peekErr == io.EOF {
+				last = r.final
+			} else if peekErr != nil {
+				return 0, peekErr
+			}
+		}
+
+		r.sealed = r.aead.Seal(r.sealed[:0], chunkNonce(r.index, last), r.chunk[:n], nil)
+		r.out = r.sealed
+		r.index++
+		r.done = last || (err != nil && !r.final)
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// decryptReader decrypts content written by encryptReader as it's being read.
+type decryptReader struct {
+	aead   cipher.AEAD
+	src    *bufio.Reader
+	chunk  []byte
+	opened []byte
+	out    []byte
+	index  uint64
+	done   bool
+}
+
+func newDecryptReader(key []byte, src io.Reader) (io.Reader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	r := &decryptReader{
+		aead:  aead,
+		src:   bufio.NewReader(src),
+		chunk: make([]byte, encryptionChunkSize+aead.Overhead()),
+	}
+
+	header := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(r.src, header); err != nil || string(header) != encryptionMagic {
+		return nil, ErrCorruptEncrypted
+	}
+	return r, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		n, err := io.ReadFull(r.src, r.chunk)
+		last := false
+		switch {
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			last = true
+		case err != nil:
+			return 0, err
+		default:
+			if _, peekErr := r.src.Peek(1); peekErr == io.EOF {
+				last = true
+			} else if peekErr != nil {
+				return 0, peekErr
+			}
+		}
+
+		r.opened, err = r.aead.Open(r.opened[:0], chunkNonce(r.index, last), r.chunk[:n], nil)
+		if err != nil {
+			return 0, ErrCorruptEncrypted
+		}
+		r.out = r.opened
+		r.index++
+		r.done = last
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
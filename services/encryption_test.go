@@ -0,0 +1,204 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+// sealedChunkSize is the size of a full chunk once encrypted.
+const sealedChunkSize = encryptionChunkSize + gcmTagSize
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testPlaintext(t *testing.T, size int) []byte {
+	t.Helper()
+	plaintext := make([]byte, size)
+	if _, err := rand.Read(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	return plaintext
+}
+
+func encrypt(t *testing.T, key, plaintext []byte) []byte {
+	t.Helper()
+	r, err := newEncryptReader(key, bytes.NewReader(plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("encrypting: %v", err)
+	}
+	return ciphertext
+}
+
+func decrypt(key, ciphertext []byte) ([]byte, error) {
+	r, err := newDecryptReader(key, bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	sizes := []int{0, 1, encryptionChunkSize - 1, encryptionChunkSize, encryptionChunkSize + 1, 3*encryptionChunkSize + 100, 4 * encryptionChunkSize}
+	key := testKey(t)
+	for _, size := range sizes {
+		plaintext := testPlaintext(t, size)
+		ciphertext := encrypt(t, key, plaintext)
+		if got, want := int64(len(ciphertext)), encryptedSize(int64(size)); got != want {
+			t.Errorf("size %d: ciphertext is %d bytes, encryptedSize says %d", size, got, want)
+		}
+		decrypted, err := decrypt(key, ciphertext)
+		if err != nil {
+			t.Errorf("size %d: decrypting: %v", size, err)
+			continue
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("size %d: decrypted content differs from the original", size)
+		}
+	}
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+	key := testKey(t)
+	plaintext := testPlaintext(t, 3*encryptionChunkSize+100)
+	ciphertext := encrypt(t, key, plaintext)
+	header := len(encryptionMagic)
+	chunk := func(i int) []byte {
+		start := header + i*sealedChunkSize
+		return ciphertext[start:min(start+sealedChunkSize, len(ciphertext))]
+	}
+
+	flipped := bytes.Clone(ciphertext)
+	flipped[header+sealedChunkSize+10] ^= 1
+	reordered := bytes.Join([][]byte{[]byte(encryptionMagic), chunk(1), chunk(0), chunk(2), chunk(3)}, nil)
+
+	tests := []struct {
+		name       string
+		ciphertext []byte
+	}{
+		{"truncated at a chunk boundary", ciphertext[:header+3*sealedChunkSize]},
+		{"truncated inside a chunk", ciphertext[:len(ciphertext)-5]},
+		{"last chunk dropped", bytes.Join([][]byte{ciphertext[:header+2*sealedChunkSize], chunk(3)}, nil)},
+		{"chunks reordered", reordered},
+		{"bit flipped", flipped},
+		{"bad header", append([]byte("XXXX"), ciphertext[header:]...)},
+		{"empty", nil},
+		{"wrong key", encrypt(t, testKey(t), plaintext)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := decrypt(key, test.ciphertext); !errors.Is(err, ErrCorruptEncrypted) {
+				t.Errorf("got error %v, want ErrCorruptEncrypted", err)
+			}
+		})
+	}
+}
+
+func TestPartEncryptionMatchesStream(t *testing.T) {
+	key := testKey(t)
+	tests := []struct {
+		name  string
+		parts []int
+	}{
+		{"short last part", []int{2 * encryptionChunkSize, encryptionChunkSize + 100}},
+		{"full last part", []int{encryptionChunkSize, 2 * encryptionChunkSize, 2 * encryptionChunkSize}},
+		{"tiny last part", []int{encryptionChunkSize, 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plaintext := testPlaintext(t, sum(test.parts))
+			want := encrypt(t, key, plaintext)
+
+			var got []byte
+			offset := 0
+			for i, length := range test.parts {
+				final := i == len(test.parts)-1
+				index := uint64(offset / encryptionChunkSize)
+				r, err := newPartEncryptReader(key, bytes.NewReader(plaintext[offset:offset+length]), index, final)
+				if err != nil {
+					t.Fatal(err)
+				}
+				part, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatalf("encrypting part %d: %v", i, err)
+				}
+				got = append(got, part...)
+				offset += length
+			}
+
+			if !bytes.Equal(got, want) {
+				t.Fatal("parts don't concatenate to the stream encryption")
+			}
+			decrypted, err := decrypt(key, got)
+			if err != nil {
+				t.Fatalf("decrypting: %v", err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Fatal("decrypted content differs from the original")
+			}
+		})
+	}
+}
+
+func sum(values []int) int {
+	total := 0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+func TestDataKeyWrapping(t *testing.T) {
+	dataKey, keySalt, err := newDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := wrapDataKey(dataKey, "correct horse", keySalt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unwrapped, err := unwrapDataKey(wrapped, "correct horse", keySalt)
+	if err != nil {
+		t.Fatalf("unwrapping: %v", err)
+	}
+	if !bytes.Equal(unwrapped, dataKey) {
+		t.Fatal("unwrapped key differs from the data key")
+	}
+
+	otherSalt := bytes.Clone(keySalt)
+	otherSalt[0] ^= 1
+	tampered := []byte(wrapped)
+	tampered[len(tampered)-1] ^= 1
+	tests := []struct {
+		name     string
+		wrapped  string
+		password string
+		salt     []byte
+	}{
+		{"wrong password", wrapped, "battery staple", keySalt},
+		{"wrong salt", wrapped, "correct horse", otherSalt},
+		{"tampered", string(tampered), "correct horse", keySalt},
+		{"not hex", "zz" + wrapped, "correct horse", keySalt},
+		{"too short", wrapped[:8], "correct horse", keySalt},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := unwrapDataKey(test.wrapped, test.password, test.salt); !errors.Is(err, ErrInvalidDataKey) {
+				t.Errorf("got error %v, want ErrInvalidDataKey", err)
+			}
+		})
+	}
+}
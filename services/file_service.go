@@ -1,13 +1,20 @@
 package services
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
-	"encoding/json"
+	"encoding/hex"
 	"errors"
+	"filevault/config"
 	"filevault/utils"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -27,46 +34,93 @@ var (
 	ErrJSONUnmarshal       = errors.New("Failed to unmarshal data")
 	ErrFileUpload          = errors.New("Failed to upload file to filesystem")
 	ErrFileNotExistent     = errors.New("File doesn't exist")
+	ErrDestinationExists   = errors.New("Destination already exists. Use --force to overwrite it")
+	ErrChecksumMismatch    = errors.New("Downloaded content doesn't match the stored checksum")
+	ErrUploadInterrupted   = errors.New("Upload interrupted, nothing was stored")
 )
 
 type FileService struct {
 	db *sql.DB
 	conn *redis.Client
+	store BlobStore
+	config *config.Config
+	// cwd is the folder relative paths start from
+	cwd workingFolder
+	// progress is told how transfers are going, see SetProgressReporter
+	progress ProgressReporter
+	// writes serializes the database writes of uploads running at the same
+	// time (see UploadFiles). SQLite allows a single writer, and a
+	// transaction that reads before it writes fails instead of waiting when
+	// another connection is writing
+	writes sync.Mutex
 }
 
 type FileMetadata struct {
 	FileId     string    `json:"file_id"` // UUID
 	FileName   string    `json:"file_name"`
 	Size       int64     `json:"size"`        // In bytes
-	Path       string    `json:"path"`        // ./uploads/notes.txt"
+	Path       string    `json:"path"`        // Blob store key of the content, a file path in metadata.json
+	Checksum   string    `json:"checksum"`    // Hex encoded SHA-256 of the content
 	UploadedAt time.Time `json:"uploaded_at"` // Iykyk
 
 }
 
-func NewFileService(db *sql.DB, conn *redis.Client) *FileService {
+func NewFileService(db *sql.DB, conn *redis.Client, store BlobStore, cfg *config.Config) *FileService {
 	return &FileService{
 		db:db,
 		conn: conn,
+		store: store,
+		config: cfg,
 	}
 }
 
-// UploadFiles uploads files to the server.
-// It checks if the "uploads" directory exists in the storage subdirectory.
+// UploadFile uploads a file to the vault and prints the version it was
+// stored as. The content is encrypted with a key derived from the user's
+// data key and written to the blob store, under a key derived from its
+// digest so identical content is stored once (see blobs.go), and the file
+// and its new version are recorded in the database.
 // Parameters:
 //   - pathname: The path of the file to be uploaded.
-func (s *FileService) UploadFile(pathname string) error {
-	// Check if the "uploads" directory exists in the storage subdirectory
-	// If it doesn't exist, create it.
-	// Then extract the file metadata, generate UUID for file and then upload the file
-	// Returning it's UUID
+//   - folder: The vault folder to upload into, the current folder when empty.
+//
+// Uploads are all or nothing: the content is flushed to storage before the
+// file record is committed, and content written for an upload that fails or
+// is interrupted is removed again. Large files are the exception, the parts
+// they stored are kept for ResumeUpload.
+func (s *FileService) UploadFile(pathname, folder string) error {
+	uploaded, err := s.upload(pathname, folder, false)
+	if err != nil {
+		return err
+	}
+	fmt.Println(uploaded)
+	return nil
+}
+
+// uploadedVersion is the version of a file an upload stored.
+type uploadedVersion struct {
+	fileName string
+	fileId   string
+	version  int
+}
+
+func (v *uploadedVersion) String() string {
+	return fmt.Sprintf("Stored %s as version %d of file %s", v.fileName, v.version, v.fileId)
+}
+
+// upload stores a file in a folder. When resuming, the folder is the one the
+// interrupted upload of the file was going to. Nothing is printed about the
+// stored version, so uploads can run side by side under a progress bar.
+func (s *FileService) upload(pathname, folder string, resume bool) (*uploadedVersion, error) {
+	// Check the user and the file, then write the content to the blob store
+	// and record the file, or a new version of it, pointing at the blob
 
 	// Ensure user is logged in 
 	if !utils.ValidateUser(s.conn) {
-		return errors.New("user is not logged in")
+		return nil, errors.New("user is not logged in")
 	}
 
 	if pathname == "" {
-		return ErrMissingPathname
+		return nil, ErrMissingPathname
 	}
 
 	// Check if the file exists
@@ -76,236 +130,586 @@ func (s *FileService) UploadFile(pathname string) error {
 		fileExists = false
 	}
 	if !fileExists || osStat.IsDir() {
-		return ErrInvalidFileFormat
+		return nil, ErrInvalidFileFormat
 	}
 
-	// Check that the uploads folder exists
-	uploadPath := "./storage/uploads"
-	if _, err := os.Stat(uploadPath); os.IsNotExist(err) {
-		err = os.MkdirAll(uploadPath, os.ModePerm) // So modeperm is like the 777 in chmod 777
-		if err != nil {
-			return err
-		}
+	// Get user ID from the key value pair [sessionToken -> userId]
+	sessionToken, err := utils.GetSessionTokenFromFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session token: %w", err)
 	}
-
-	// Enough shalaye, let's upload the file!
-	uploadedFile, err := os.Open(pathname)
+	// Get user ID by session token
+	userId, err := utils.GetUserID(sessionToken, s.conn, s.db)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to get user ID: %w", err)
 	}
-	defer uploadedFile.Close()
-	destinationPath := uploadPath + "/" + osStat.Name()
-	destinationFile, err := os.Create(destinationPath)
+
+	valid, err := utils.CheckIsAuthenticated(sessionToken, userId, s.conn, s.db)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("Failed to validate user because %w", err)
+	}
+	if !valid {
+		return nil, fmt.Errorf("User isn't authenticated")
 	}
 
-	// Copy the content of the old file to the new file
-	_, err = io.Copy(destinationFile, uploadedFile)
+	// An interrupted upload of the file is continued when resuming, and
+	// otherwise replaced by this one
+	sourcePath, err := filepath.Abs(pathname)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	sessions, err := s.uploadSessions(userId, sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	var previous *uploadSession
+	if len(sessions) > 0 {
+		previous = sessions[len(sessions)-1]
+	}
+	var folderId string
+	if resume {
+		if previous == nil {
+			return nil, ErrNoInterruptedUpload
+		}
+		folderId = previous.folderId
+		if _, err := s.folderPath(folderId); errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("%w: the folder the upload was going to has been removed", ErrFolderNotFound)
+		} else if err != nil {
+			return nil, err
+		}
+	} else if folderId, err = s.resolveFolder(userId, folder); err != nil {
+		return nil, err
 	}
-	defer destinationFile.Close()
 
-	// Get user ID from the key value pair [sessionToken -> userId]
-	sessionToken, err := utils.GetSessionTokenFromFile()
+	// Refuse uploads that don't fit in the user's quota before storing anything
+	if err := s.checkQuota(userId, osStat.Size(), ""); err != nil {
+		return nil, err
+	}
+
+	// Enough shalaye, let's upload the file!
+	file, err := os.Open(pathname)
 	if err != nil {
-		return fmt.Errorf("failed to get session token: %w", err)
+		return nil, err
 	}
-	// Get user ID by session token
-	userId, err := utils.GetUserID(sessionToken, s.conn, s.db)
+	defer file.Close()
+
+	// Ctrl+C during the upload rolls it back instead of killing the CLI
+	// halfway through
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	uploadedFile := &interruptibleReader{ctx: ctx, ReadSeeker: file}
+
+	dataKey, err := s.dataKey()
 	if err != nil {
-		return fmt.Errorf("failed to get user ID: %w", err)
+		return nil, err
 	}
 
-	valid, err := utils.CheckIsAuthenticated(sessionToken, userId, s.conn, s.db)
+	mimeType, err := detectMimeType(osStat.Name(), uploadedFile)
 	if err != nil {
-		return fmt.Errorf("Failed to validate user because %w", err)
+		return nil, fmt.Errorf("%w: %v", ErrReadingFileContent, err)
 	}
-	if !valid {
-		return fmt.Errorf("User isn't authenticated")
+
+	// Large files are stored in parts so an interrupted upload can resume
+	var session *uploadSession
+	switch {
+	case previous != nil && resume && osStat.Size() > uploadPartSize && previous.size == osStat.Size() && previous.modifiedAt.Equal(osStat.ModTime()):
+		session = previous
+	case previous != nil:
+		if resume {
+			fmt.Printf("%s changed since the upload was interrupted, starting over\n", osStat.Name())
+		}
+		if err := s.discardUploadSession(previous); err != nil {
+			return nil, err
+		}
+	}
+	if session == nil && osStat.Size() > uploadPartSize {
+		session = &uploadSession{
+			id:         uuid.New().String(),
+			userId:     userId,
+			folderId:   folderId,
+			fileName:   osStat.Name(),
+			sourcePath: sourcePath,
+			size:       osStat.Size(),
+			modifiedAt: osStat.ModTime(),
+		}
 	}
 
-	// Store the metadata of the file in metadata.json
+	// Store the content encrypted, under a key derived from its digest, so
+	// identical uploads share one blob. Text-heavy content is compressed first
+	compress := s.config.Compress && compressible(osStat.Name(), mimeType, osStat.Size())
+	blob, err := s.writeBlob(uploadedFile, osStat.Name(), osStat.Size(), dataKey, compress, session)
+	if err != nil {
+		switch {
+		case session != nil && session.saved && ctx.Err() != nil:
+			return nil, fmt.Errorf("%w. Continue it with vault upload --resume %s", ErrUploadPaused, pathname)
+		case ctx.Err() != nil:
+			return nil, ErrUploadInterrupted
+		case session != nil && session.saved:
+			return nil, fmt.Errorf("%w: %v. Continue it with vault upload --resume %s", ErrFileUpload, err, pathname)
+		}
+		return nil, fmt.Errorf("%w: %v", ErrFileUpload, err)
+	}
+	// Until the file record is committed nothing references a new blob, so
+	// it's removed again when the upload fails from here on
+	committed := false
+	defer func() {
+		if blob.created && !committed {
+			s.removeUnrecordedBlob(blob.key)
+		}
+	}()
+
+	// Describe the new file, or new version of an existing file
 	fileMetadata := FileMetadata{
 		FileId:     uuid.New().String(),
 		FileName:   osStat.Name(),
-		Size:       osStat.Size(),
-		Path:       destinationPath,
+		Size:       blob.size,
+		Path:       blob.key,
+		Checksum:   blob.checksum,
 		UploadedAt: time.Now(),
 	}
-	// Add database record of metadata
-	fileRecord, err := s.db.Prepare("INSERT INTO files (id, file_name, user_id, size, file_path, uploaded_at) VALUES (?, ?, ?, ?, ?, ?)")
+	// Add database record of metadata along with its reference to the blob.
+	// Uploading a name the folder already has adds a new version of that file.
+	// The quota is checked again as other uploads may have used it meanwhile
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	if err := s.checkQuota(userId, blob.size, blob.key); err != nil {
+		return nil, err
+	}
+	tx, err := s.db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to prepare database statement: %w", err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	var existingId string
+	err = tx.QueryRow("SELECT id FROM files WHERE user_id = ? AND folder_id IS ? AND file_name = ? AND deleted_at IS NULL ORDER BY uploaded_at DESC LIMIT 1",
+		userId, folderArg(folderId), fileMetadata.FileName).Scan(&existingId)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec("INSERT INTO files (id, file_name, user_id, folder_id, size, file_path, checksum, mime_type, encrypted, codec, uploaded_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, 1, ?, ?)",
+			fileMetadata.FileId, fileMetadata.FileName, userId, folderArg(folderId), fileMetadata.Size, fileMetadata.Path, fileMetadata.Checksum, mimeType, blob.codec, fileMetadata.UploadedAt)
+	case err == nil:
+		fileMetadata.FileId = existingId
+		_, err = tx.Exec("UPDATE files SET size = ?, file_path = ?, checksum = ?, mime_type = ?, encrypted = 1, codec = ?, uploaded_at = ? WHERE id = ?",
+			fileMetadata.Size, fileMetadata.Path, fileMetadata.Checksum, mimeType, blob.codec, fileMetadata.UploadedAt, fileMetadata.FileId)
 	}
-	defer fileRecord.Close()
-	_, err = fileRecord.Exec(fileMetadata.FileId, fileMetadata.FileName, userId, fileMetadata.Size, fileMetadata.Path, fileMetadata.UploadedAt)
 	if err != nil {
-		return fmt.Errorf("failed to execute database statement: %w", err)
+		return nil, fmt.Errorf("failed to execute database statement: %w", err)
 	}
-
-	// Open metadata.json
-	databaseFile, err := os.Open("./storage/metadata.json")
+	version, err := addVersion(tx, fileMetadata.FileId, fileMetadata.Size, fileMetadata.Path, fileMetadata.Checksum, mimeType, true, blob.codec, fileMetadata.UploadedAt)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to record version: %w", err)
 	}
-	defer databaseFile.Close()
-
-	// Read the file content
-	fileContent, err := os.ReadFile("./storage/metadata.json")
+	if err := retainBlob(tx, blob.key, blob.storedSize); err != nil {
+		return nil, fmt.Errorf("failed to record blob reference: %w", err)
+	}
+	if err := s.resealGrants(tx, fileMetadata.FileId, blob.checksum, true); err != nil {
+		return nil, fmt.Errorf("failed to update grants: %w", err)
+	}
+	// Index the text of the file so its content can be searched
+	if _, err := uploadedFile.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := s.indexContent(tx, fileMetadata.FileId, fileMetadata.FileName, uploadedFile); err != nil {
+		return nil, fmt.Errorf("failed to index file content: %w", err)
+	}
+	removals, err := s.pruneVersions(tx, fileMetadata.FileId)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to prune versions: %w", err)
 	}
-	var metadataList []FileMetadata
-	// Handle empty file or initialize empty array
-	if len(fileContent) == 0 || string(fileContent) == "{}" || string(fileContent) == "" {
-		metadataList = []FileMetadata{}
-	} else {
-		err = json.Unmarshal(fileContent, &metadataList)
-		if err != nil {
-			var singleMetadata FileMetadata
-			err = json.Unmarshal(fileContent, &singleMetadata)
-			if err != nil {
-				return err
-			}
-			metadataList = []FileMetadata{singleMetadata}
+	// The upload is complete, its parts aren't needed anymore
+	if session != nil && session.saved {
+		if err := deleteUploadSession(tx, session.id); err != nil {
+			return nil, fmt.Errorf("failed to delete upload: %w", err)
 		}
+		removals = append(removals, func() error {
+			s.removeParts(session)
+			return nil
+		})
 	}
-	// Update the metadata list with the new file metadata
-	metadataList = append(metadataList, fileMetadata)
-	updatedMetadata, err := json.Marshal(metadataList)
-	if err != nil {
-		return err
+	if ctx.Err() != nil {
+		return nil, ErrUploadInterrupted
 	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit file record: %w", err)
+	}
+	committed = true
+	runRemovals(removals)
+
+	return &uploadedVersion{fileName: fileMetadata.FileName, fileId: fileMetadata.FileId, version: version}, nil
+}
+
+// ListOptions controls which of the user's files ListUploaded shows and in
+// what order.
+type ListOptions struct {
+	SortBy     string // "name", "size" or "date" (default)
+	Descending bool
+	Limit      int // 0 shows every file
+	Offset     int
+	// NameFilter is a glob like "*.pdf" when it contains * or ?, otherwise
+	// names containing it match, ignoring case
+	NameFilter string
+	// Tag only lists files with this tag
+	Tag string
+	// SharedWithMe lists the files others have shared with the user instead
+	SharedWithMe bool
+}
+
+// listSortColumns maps ListOptions.SortBy to the column it sorts on.
+var listSortColumns = map[string]string{
+	"name": "file_name COLLATE NOCASE",
+	"size": "size",
+	"date": "uploaded_at",
+}
 
-	// Write to the database
-	err = os.WriteFile("./storage/metadata.json", updatedMetadata, 0644)
+// ListUploaded prints the files of the logged in user, or the files shared
+// with them.
+func (s *FileService) ListUploaded(opts ListOptions) error {
+	userId, err := s.currentUserID()
 	if err != nil {
 		return err
 	}
-	return nil
-}
 
-func (s *FileService) ListUploaded() error {
-	// Check if the metadata.json file
+	if opts.SortBy == "" {
+		opts.SortBy = "date"
+	}
+	sortColumn, ok := listSortColumns[opts.SortBy]
+	if !ok {
+		return fmt.Errorf("can't sort by %q, use name, size or date", opts.SortBy)
+	}
 
-	// Ensure user is logged in 
-	if !utils.ValidateUser(s.conn) {
-		return errors.New("user is not logged in")
+	where := "user_id = ? AND deleted_at IS NULL"
+	if opts.SharedWithMe {
+		where = "id IN (SELECT file_id FROM file_grants WHERE user_id = ?) AND deleted_at IS NULL"
+	}
+	args := []any{userId}
+	if opts.Tag != "" {
+		where += " AND id IN (SELECT file_id FROM file_tags WHERE tag = ?)"
+		args = append(args, normalizeTag(opts.Tag))
+	}
+	if opts.NameFilter != "" {
+		condition, arg := nameCondition(opts.NameFilter)
+		where += " AND " + condition
+		args = append(args, arg)
 	}
 
-	const pathName = "./storage/metadata.json"
-	fileExists := true
-	osStat, err := os.Stat(pathName)
-	if err != nil {
-		fileExists = false
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM files WHERE "+where, args...).Scan(&total); err != nil {
+		return fmt.Errorf("failed to count files: %w", err)
 	}
-	if !fileExists || osStat.IsDir() {
-		return ErrInvalidFileFormat
+
+	query := "SELECT id, file_name, size, " + storedSizeColumn + ", uploaded_at FROM files WHERE " + where + " ORDER BY " + sortColumn
+	if opts.Descending {
+		query += " DESC"
+	}
+	// A negative LIMIT means no limit in SQLite
+	limit := opts.Limit
+	if limit == 0 {
+		limit = -1
 	}
+	query += " LIMIT ? OFFSET ?"
+	args = append(args, limit, opts.Offset)
 
-	// Read the file content
-	fileContent, err := os.ReadFile("./storage/metadata.json")
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to query files: %w", err)
 	}
-	// File metadata
-	fileMetadata := []FileMetadata{}
+	defer rows.Close()
 
-	// Unmarshal/Parse the JSON
-	err = json.Unmarshal(fileContent, &fileMetadata)
+	count, err := printFiles(rows)
 	if err != nil {
 		return err
 	}
+	switch {
+	case total == 0:
+		fmt.Println("No files found")
+	case count < total:
+		fmt.Printf("Showing %d-%d of %d files\n", min(opts.Offset+1, total), opts.Offset+count, total)
+	}
+	return nil
+}
+
+// storedSizeColumn selects the size of the content of a file in storage,
+// once compressed and encrypted. Content outside the blob store is stored
+// as is.
+const storedSizeColumn = "COALESCE((SELECT size FROM blobs WHERE hash = files.file_path), (SELECT size FROM blobs WHERE hash = files.checksum), files.size)"
+
+// printFiles prints a table of files queried as id, file_name, size,
+// storedSizeColumn and uploaded_at, returning how many there were.
+func printFiles(rows *sql.Rows) (int, error) {
 	// Print table header
-	fmt.Println("ID                                   | Name                   | Size      | Uploaded At")
-	fmt.Println("-------------------------------------+------------------------+-----------+--------------------")
-	if len(fileMetadata) == 0 {
-		fmt.Println("No entries found in metadata.json")
-		return nil
-	}
-	for _, entry := range fileMetadata {
-		fmt.Printf("%-36s | %-22s | %-9s | %s\n",
+	fmt.Println("ID                                   | Name                   | Size      | Stored    | Uploaded At")
+	fmt.Println("-------------------------------------+------------------------+-----------+-----------+--------------------")
+	count := 0
+	for rows.Next() {
+		var entry FileMetadata
+		var storedSize int64
+		if err := rows.Scan(&entry.FileId, &entry.FileName, &entry.Size, &storedSize, &entry.UploadedAt); err != nil {
+			return count, fmt.Errorf("failed to read file: %w", err)
+		}
+		fmt.Printf("%-36s | %-22s | %-9s | %-9s | %s\n",
 			entry.FileId,
 			entry.FileName,
 			utils.GetSizeField(entry.Size),
+			utils.GetSizeField(storedSize),
 			entry.UploadedAt,
 		)
+		count++
 	}
-	return nil
+	if err := rows.Err(); err != nil {
+		return count, fmt.Errorf("failed to read files: %w", err)
+	}
+	return count, nil
+}
+
+// nameCondition matches file names against a glob like "*.pdf" when the
+// pattern contains wildcards, otherwise against names containing it. Both
+// ignore case, like LIKE does.
+func nameCondition(pattern string) (string, any) {
+	if strings.ContainsAny(pattern, "*?[") {
+		return "lower(file_name) GLOB lower(?)", pattern
+	}
+	return `file_name LIKE ? ESCAPE '\'`, "%" + escapeLike(pattern) + "%"
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern using \ as escape.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
 }
 
 func (s *FileService) DeleteFile(fileId string) error {
+	if _, err := s.authorize(fileId, actionDelete); err != nil {
+		return err
+	}
 
-	// Ensure user is logged in 
-	if !utils.ValidateUser(s.conn) {
-		return errors.New("user is not logged in")
+	// Move the file to the trash, it's only purged later
+	if err := s.trashFileRecord(fileId); err != nil {
+		return err
 	}
+	fmt.Printf("File with ID %s has been moved to the trash\n", fileId)
+	return nil
+}
 
-	// Ensure the fileId was passed
-	if fileId == "" {
-		fmt.Print("Error fileID wasn't passed")
-		return errors.New("file ID is missing")
+// deleteFileRecord removes a file's database record and all its versions,
+// deleting their content when nothing else references it.
+func (s *FileService) deleteFileRecord(fileId string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
-	// Check if the metadata.json file exists
-	metadataPath := "./storage/metadata.json"
-	fileExists := true
-	osStat, err := os.Stat(metadataPath)
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT file_path, checksum FROM file_versions WHERE file_id = ?", fileId)
 	if err != nil {
-		fileExists = false
+		return fmt.Errorf("failed to query versions: %w", err)
+	}
+	var contents [][2]string
+	for rows.Next() {
+		var filePath, checksum sql.NullString
+		if err := rows.Scan(&filePath, &checksum); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read version: %w", err)
+		}
+		contents = append(contents, [2]string{filePath.String, checksum.String})
 	}
-	if !fileExists || osStat.IsDir() {
-		return ErrInvalidFileFormat
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read versions: %w", err)
+	}
+
+	var removals []func() error
+	for _, content := range contents {
+		removal, err := s.releaseContent(tx, content[0], content[1])
+		if err != nil {
+			return fmt.Errorf("failed to release content: %w", err)
+		}
+		if removal != nil {
+			removals = append(removals, removal)
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM file_versions WHERE file_id = ?", fileId); err != nil {
+		return fmt.Errorf("failed to delete versions: %w", err)
 	}
+	if _, err := tx.Exec("DELETE FROM file_grants WHERE file_id = ?", fileId); err != nil {
+		return fmt.Errorf("failed to delete grants: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM file_tags WHERE file_id = ?", fileId); err != nil {
+		return fmt.Errorf("failed to delete tags: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM file_attributes WHERE file_id = ?", fileId); err != nil {
+		return fmt.Errorf("failed to delete attributes: %w", err)
+	}
+	if err := s.removeFromIndex(tx, fileId); err != nil {
+		return fmt.Errorf("failed to remove indexed content: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM files WHERE id = ?", fileId); err != nil {
+		return fmt.Errorf("failed to delete file record: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit file deletion: %w", err)
+	}
+	runRemovals(removals)
+	return nil
+}
 
-	// Read the file content
-	fileContent, err := os.ReadFile(metadataPath)
+// DownloadFile copies a file the logged in user owns, or that's been shared
+// with them, out of the vault.
+// Parameters:
+//   - fileId: The ID of the file to retrieve.
+//   - destination: Where to write the file. Defaults to the file's name in the
+//     current directory; when it's a directory the file's name is appended.
+//   - overwrite: Whether an existing destination may be replaced.
+func (s *FileService) DownloadFile(fileId, destination string, overwrite bool) error {
+	access, err := s.authorize(fileId, actionRead)
 	if err != nil {
 		return err
 	}
-	// File metadata
-	fileMetadata := []FileMetadata{}
+	fileName, checksum := access.fileName, access.checksum
+
+	// Work out where the file should go
+	if destination == "" {
+		destination = fileName
+	} else if destStat, err := os.Stat(destination); err == nil && destStat.IsDir() {
+		destination = filepath.Join(destination, fileName)
+	}
+	if _, err := os.Stat(destination); err == nil && !overwrite {
+		return ErrDestinationExists
+	}
 
-	// Unmarshal/Parse the JSON
-	err = json.Unmarshal(fileContent, &fileMetadata)
+	var key []byte
+	if access.encrypted {
+		if key, err = s.contentKeyFor(access); err != nil {
+			return err
+		}
+	}
+	storedFile, err := s.openStoredFile(access.filePath, checksum, access.encrypted, access.codec, key)
 	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrFileNotExistent
+		}
 		return err
 	}
-	// Read the metadata file and check if there exists an entry with the fileId
-	for _, x := range fileMetadata {
-		if x.FileId == fileId {
-			// If the file exists, delete it from the filesystem
-			if _, err := os.Stat(x.Path); err == nil {
-				err = os.Remove(x.Path)
-				if err != nil {
-					return ErrFileUpload
-				}
-			} else {
-				return ErrFileNotExistent
-			}
-
-			// Remove the entry from the metadata list
-			for i, entry := range fileMetadata {
-				if entry.FileId == fileId {
-					fileMetadata = append(fileMetadata[:i], fileMetadata[i+1:]...)
-					break
-				}
-			}
-
-			// Write the updated metadata back to the file
-			updatedMetadata, err := json.Marshal(fileMetadata)
-			if err != nil {
-				return ErrMetadataJSONMarshal
-			}
-			err = os.WriteFile("./storage/metadata.json", updatedMetadata, 0644)
-			if err != nil {
-				return ErrDatabaseWriteFail
-			}
-			fmt.Printf("File with ID %s has been deleted successfully\n", fileId)
-			return nil
-		}
+	defer storedFile.Close()
+
+	// Copy into a temporary file next to the destination so a failed or
+	// corrupted download never leaves a partial file behind
+	tempFile, err := os.CreateTemp(filepath.Dir(destination), ".vault-download-*")
+	if err != nil {
+		return err
 	}
+	defer os.Remove(tempFile.Name())
 
+	hasher := sha256.New()
+	transfer := s.track("Downloading "+fileName, access.size)
+	_, err = io.Copy(io.MultiWriter(tempFile, hasher), progressReader{Reader: storedFile, transfer: transfer})
+	transfer.Finish()
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Files uploaded before checksums were recorded can't be verified
+	if checksum == "" {
+		fmt.Println("Warning: no checksum stored for this file, skipping verification")
+	} else if hex.EncodeToString(hasher.Sum(nil)) != checksum {
+		return ErrChecksumMismatch
+	}
+
+	if err := os.Rename(tempFile.Name(), destination); err != nil {
+		return err
+	}
+	fmt.Printf("File with ID %s has been saved to %s\n", fileId, destination)
 	return nil
 }
+
+// openStoredFile opens the plaintext content of a file record. Content lives
+// in the blob store, except for files uploaded before blobs existed which
+// are read from their own path on disk. Encrypted content is decrypted with
+// key as it's read, and compressed content decompressed.
+func (s *FileService) openStoredFile(filePath, checksum string, encrypted bool, codec string, key []byte) (io.ReadCloser, error) {
+	blobKey, err := storedBlobKey(s.db, filePath, checksum)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blob: %w", err)
+	}
+	if blobKey == "" {
+		return os.Open(filePath)
+	}
+	if !encrypted {
+		return s.store.Get(blobKey)
+	}
+
+	blob, err := s.store.Get(blobKey)
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := newDecryptReader(key, blob)
+	if err != nil {
+		blob.Close()
+		return nil, err
+	}
+	decompressed, err := newDecompressReader(codec, decrypted)
+	if err != nil {
+		blob.Close()
+		return nil, err
+	}
+	return readCloser{Reader: decompressed, Closer: blob}, nil
+}
+
+// interruptibleReader fails reads once its context is cancelled, so copies
+// stop when the user interrupts them.
+type interruptibleReader struct {
+	ctx context.Context
+	io.ReadSeeker
+}
+
+func (r *interruptibleReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ReadSeeker.Read(p)
+}
+
+// readCloser pairs a reader with the Close of the stream underneath it.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// dataKey returns the data key unwrapped when the current session logged in.
+func (s *FileService) dataKey() ([]byte, error) {
+	sessionToken, err := utils.GetSessionTokenFromFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session token: %w", err)
+	}
+	encodedKey, err := s.conn.Get(context.Background(), dataKeyCacheKey(sessionToken)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, ErrVaultLocked
+		}
+		return nil, fmt.Errorf("failed to get data key: %w", err)
+	}
+	dataKey, err := hex.DecodeString(encodedKey)
+	if err != nil {
+		return nil, ErrInvalidDataKey
+	}
+	return dataKey, nil
+}
+
+// currentUserID returns the ID of the user owning the current session.
+func (s *FileService) currentUserID() (string, error) {
+	// Ensure user is logged in
+	if !utils.ValidateUser(s.conn) {
+		return "", errors.New("user is not logged in")
+	}
+
+	sessionToken, err := utils.GetSessionTokenFromFile()
+	if err != nil {
+		return "", fmt.Errorf("failed to get session token: %w", err)
+	}
+	userId, err := utils.GetUserID(sessionToken, s.conn, s.db)
+	if err != nil {
+		return "", fmt.Errorf("failed to get user ID: %w", err)
+	}
+	return userId, nil
+}
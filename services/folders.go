@@ -0,0 +1,416 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"filevault/utils"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Folders form a tree per user through their parent_id, the root folder
+// being implicit: files and folders in it have no folder or parent. Paths
+// are written like "/reports/2025", or relative to the current folder
+// with "." and ".." as usual. Folder IDs are never shown to users, the
+// empty string stands for the root folder.
+
+var (
+	ErrFolderNotFound    = errors.New("Folder doesn't exist")
+	ErrFolderExists      = errors.New("A folder with this name already exists")
+	ErrInvalidFolderName = errors.New("Folder names can't be empty, . or .. or contain /")
+	ErrFolderNotEmpty    = errors.New("Folder isn't empty. Files in the trash count too")
+)
+
+// workingFolder is the current folder of the REPL, which commands resolve
+// relative paths against.
+type workingFolder struct {
+	userId   string
+	folderId string
+}
+
+// folderArg returns a folder ID as a query parameter, NULL for the root.
+func folderArg(folderId string) any {
+	if folderId == "" {
+		return nil
+	}
+	return folderId
+}
+
+// currentFolder returns the user's current folder. It's the root when
+// another user changed folders before them.
+func (s *FileService) currentFolder(userId string) string {
+	if s.cwd.userId != userId {
+		return ""
+	}
+	return s.cwd.folderId
+}
+
+func validFolderName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+// resolveFolder returns the ID of the folder at path.
+func (s *FileService) resolveFolder(userId, path string) (string, error) {
+	folderId := s.currentFolder(userId)
+	if strings.HasPrefix(path, "/") {
+		folderId = ""
+	}
+	for _, name := range strings.Split(path, "/") {
+		var err error
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			folderId, err = s.parentFolder(folderId)
+		default:
+			folderId, err = s.childFolder(userId, folderId, name)
+			if err == sql.ErrNoRows {
+				return "", fmt.Errorf("%w: %s", ErrFolderNotFound, path)
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return folderId, nil
+}
+
+func (s *FileService) childFolder(userId, parentId, name string) (string, error) {
+	var folderId string
+	err := s.db.QueryRow("SELECT id FROM folders WHERE user_id = ? AND parent_id IS ? AND name = ?", userId, folderArg(parentId), name).Scan(&folderId)
+	return folderId, err
+}
+
+func (s *FileService) parentFolder(folderId string) (string, error) {
+	if folderId == "" {
+		return "", nil
+	}
+	var parentId sql.NullString
+	if err := s.db.QueryRow("SELECT parent_id FROM folders WHERE id = ?", folderId).Scan(&parentId); err != nil {
+		return "", fmt.Errorf("failed to query folder: %w", err)
+	}
+	return parentId.String, nil
+}
+
+// folderPath returns the absolute path of a folder.
+func (s *FileService) folderPath(folderId string) (string, error) {
+	var names []string
+	for folderId != "" {
+		var name string
+		var parentId sql.NullString
+		if err := s.db.QueryRow("SELECT name, parent_id FROM folders WHERE id = ?", folderId).Scan(&name, &parentId); err != nil {
+			return "", fmt.Errorf("failed to query folder: %w", err)
+		}
+		names = append(names, name)
+		folderId = parentId.String
+	}
+	slices.Reverse(names)
+	return "/" + strings.Join(names, "/"), nil
+}
+
+// MakeFolder creates the folder at path. With parents, missing folders
+// along the path are created too and an existing folder isn't an error.
+func (s *FileService) MakeFolder(path string, parents bool) error {
+	userId, err := s.currentUserID()
+	if err != nil {
+		return err
+	}
+
+	names := strings.FieldsFunc(path, func(r rune) bool { return r == '/' })
+	if len(names) == 0 {
+		return ErrFolderExists
+	}
+	folderId := s.currentFolder(userId)
+	if strings.HasPrefix(path, "/") {
+		folderId = ""
+	}
+	created := false
+	for i, name := range names {
+		last := i == len(names)-1
+		switch name {
+		case ".":
+			continue
+		case "..":
+			if folderId, err = s.parentFolder(folderId); err != nil {
+				return err
+			}
+			continue
+		}
+		if !validFolderName(name) {
+			return ErrInvalidFolderName
+		}
+
+		childId, err := s.childFolder(userId, folderId, name)
+		switch {
+		case err == nil && last && !parents:
+			return fmt.Errorf("%w: %s", ErrFolderExists, path)
+		case err == nil:
+			folderId = childId
+		case err == sql.ErrNoRows && (last || parents):
+			if childId, err = s.createFolder(userId, folderId, name); errors.Is(err, ErrFolderExists) {
+				return fmt.Errorf("%w: %s", ErrFolderExists, path)
+			} else if err != nil {
+				return err
+			}
+			folderId = childId
+			created = true
+		case err == sql.ErrNoRows:
+			return fmt.Errorf("%w: %s. Use --parents to create it", ErrFolderNotFound, strings.Join(names[:i+1], "/"))
+		default:
+			return fmt.Errorf("failed to query folder: %w", err)
+		}
+	}
+
+	folderPath, err := s.folderPath(folderId)
+	if err != nil {
+		return err
+	}
+	if created {
+		fmt.Printf("Created folder %s\n", folderPath)
+	} else {
+		fmt.Printf("Folder %s already exists\n", folderPath)
+	}
+	return nil
+}
+
+// createFolder adds a folder named name to the folder parentId.
+func (s *FileService) createFolder(userId, parentId, name string) (string, error) {
+	folderId := uuid.New().String()
+	_, err := s.db.Exec("INSERT INTO folders (id, user_id, parent_id, name, created_at) VALUES (?, ?, ?, ?, ?)",
+		folderId, userId, folderArg(parentId), name, time.Now())
+	if err != nil {
+		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+			return "", ErrFolderExists
+		}
+		return "", fmt.Errorf("failed to create folder: %w", err)
+	}
+	return folderId, nil
+}
+
+// RemoveFolder deletes an empty folder.
+func (s *FileService) RemoveFolder(path string) error {
+	userId, err := s.currentUserID()
+	if err != nil {
+		return err
+	}
+	folderId, err := s.resolveFolder(userId, path)
+	if err != nil {
+		return err
+	}
+	if folderId == "" {
+		return errors.New("the root folder can't be removed")
+	}
+
+	var children int
+	err = s.db.QueryRow("SELECT (SELECT COUNT(*) FROM folders WHERE parent_id = ?) + (SELECT COUNT(*) FROM files WHERE folder_id = ?)", folderId, folderId).Scan(&children)
+	if err != nil {
+		return fmt.Errorf("failed to query folder: %w", err)
+	}
+	if children > 0 {
+		return ErrFolderNotEmpty
+	}
+
+	removed, err := s.folderPath(folderId)
+	if err != nil {
+		return err
+	}
+	parentId, err := s.parentFolder(folderId)
+	if err != nil {
+		return err
+	}
+	if _, err := s.db.Exec("DELETE FROM folders WHERE id = ?", folderId); err != nil {
+		return fmt.Errorf("failed to remove folder: %w", err)
+	}
+	if s.currentFolder(userId) == folderId {
+		s.cwd.folderId = parentId
+	}
+	fmt.Printf("Removed folder %s\n", removed)
+	return nil
+}
+
+// ChangeFolder makes the folder at path the current folder. An empty path
+// goes back to the root.
+func (s *FileService) ChangeFolder(path string) error {
+	userId, err := s.currentUserID()
+	if err != nil {
+		return err
+	}
+	folderId := ""
+	if path != "" {
+		if folderId, err = s.resolveFolder(userId, path); err != nil {
+			return err
+		}
+	}
+	s.cwd = workingFolder{userId: userId, folderId: folderId}
+	return s.PrintWorkingFolder()
+}
+
+// PrintWorkingFolder prints the path of the current folder.
+func (s *FileService) PrintWorkingFolder() error {
+	userId, err := s.currentUserID()
+	if err != nil {
+		return err
+	}
+	path, err := s.folderPath(s.currentFolder(userId))
+	if err != nil {
+		return err
+	}
+	fmt.Println(path)
+	return nil
+}
+
+// ListFolder prints the folders and files in the folder at path.
+func (s *FileService) ListFolder(path string) error {
+	userId, err := s.currentUserID()
+	if err != nil {
+		return err
+	}
+	folderId, err := s.resolveFolder(userId, path)
+	if err != nil {
+		return err
+	}
+
+	folders, err := s.db.Query("SELECT name, created_at FROM folders WHERE user_id = ? AND parent_id IS ? ORDER BY name", userId, folderArg(folderId))
+	if err != nil {
+		return fmt.Errorf("failed to query folders: %w", err)
+	}
+	defer folders.Close()
+
+	fmt.Println("ID                                   | Name                   | Size      | Uploaded At")
+	fmt.Println("-------------------------------------+------------------------+-----------+--------------------")
+	count := 0
+	for folders.Next() {
+		var name string
+		var createdAt time.Time
+		if err := folders.Scan(&name, &createdAt); err != nil {
+			return fmt.Errorf("failed to read folder: %w", err)
+		}
+		fmt.Printf("%-36s | %-22s | %-9s | %s\n", "", name+"/", "<dir>", createdAt)
+		count++
+	}
+	if err := folders.Err(); err != nil {
+		return fmt.Errorf("failed to read folders: %w", err)
+	}
+
+	files, err := s.db.Query("SELECT id, file_name, size, uploaded_at FROM files WHERE user_id = ? AND folder_id IS ? AND deleted_at IS NULL ORDER BY file_name",
+		userId, folderArg(folderId))
+	if err != nil {
+		return fmt.Errorf("failed to query files: %w", err)
+	}
+	defer files.Close()
+	for files.Next() {
+		var entry FileMetadata
+		if err := files.Scan(&entry.FileId, &entry.FileName, &entry.Size, &entry.UploadedAt); err != nil {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+		fmt.Printf("%-36s | %-22s | %-9s | %s\n", entry.FileId, entry.FileName, utils.GetSizeField(entry.Size), entry.UploadedAt)
+		count++
+	}
+	if err := files.Err(); err != nil {
+		return fmt.Errorf("failed to read files: %w", err)
+	}
+	if count == 0 {
+		fmt.Println("Folder is empty")
+	}
+	return nil
+}
+
+// treeNode is a folder with what it contains, for printing trees.
+type treeNode struct {
+	name    string
+	folders []*treeNode
+	files   []string
+}
+
+// Tree prints the folder at path with everything below it.
+func (s *FileService) Tree(path string) error {
+	userId, err := s.currentUserID()
+	if err != nil {
+		return err
+	}
+	rootId, err := s.resolveFolder(userId, path)
+	if err != nil {
+		return err
+	}
+	rootPath, err := s.folderPath(rootId)
+	if err != nil {
+		return err
+	}
+
+	// Load the user's whole hierarchy at once and link it up in memory
+	nodes := map[string]*treeNode{"": {name: rootPath}}
+	parents := map[string]string{}
+	rows, err := s.db.Query("SELECT id, parent_id, name FROM folders WHERE user_id = ? ORDER BY name", userId)
+	if err != nil {
+		return fmt.Errorf("failed to query folders: %w", err)
+	}
+	var order []string
+	for rows.Next() {
+		var id, name string
+		var parentId sql.NullString
+		if err := rows.Scan(&id, &parentId, &name); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read folder: %w", err)
+		}
+		nodes[id] = &treeNode{name: name + "/"}
+		parents[id] = parentId.String
+		order = append(order, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read folders: %w", err)
+	}
+	for _, id := range order {
+		parent := nodes[parents[id]]
+		parent.folders = append(parent.folders, nodes[id])
+	}
+	if rootId != "" {
+		nodes[rootId].name = rootPath
+	}
+
+	rows, err = s.db.Query("SELECT folder_id, file_name FROM files WHERE user_id = ? AND deleted_at IS NULL ORDER BY file_name", userId)
+	if err != nil {
+		return fmt.Errorf("failed to query files: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var folderId sql.NullString
+		var name string
+		if err := rows.Scan(&folderId, &name); err != nil {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+		if node, ok := nodes[folderId.String]; ok {
+			node.files = append(node.files, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read files: %w", err)
+	}
+
+	root := nodes[rootId]
+	fmt.Println(root.name)
+	printTree(root, "")
+	return nil
+}
+
+// printTree prints what a folder contains below it, folders first.
+func printTree(node *treeNode, indent string) {
+	count := len(node.folders) + len(node.files)
+	for i := 0; i < count; i++ {
+		connector, childIndent := "├── ", indent+"│   "
+		if i == count-1 {
+			connector, childIndent = "└── ", indent+"    "
+		}
+		if i < len(node.folders) {
+			fmt.Println(indent + connector + node.folders[i].name)
+			printTree(node.folders[i], childIndent)
+		} else {
+			fmt.Println(indent + connector + node.files[i-len(node.folders)])
+		}
+	}
+}
@@ -0,0 +1,571 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"filevault/utils"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+)
+
+// Fsck cross-checks the database with the stored content. Every version
+// holds one reference to the blob it points at, so the versions, the blobs
+// table and the blob store must agree, and each files row must mirror its
+// newest version.
+//
+// With repair, problems that can be fixed without guessing are fixed:
+// content nothing references is moved to the quarantine directory rather
+// than deleted, records pointing at content that's gone are removed and
+// reference counts are recounted. Content that doesn't match its checksum
+// is only reported.
+
+var (
+	ErrFsckNotAdmin       = errors.New("Only the admins listed in FILEVAULT_ADMINS can check the vault")
+	ErrFsckRepairNotAdmin = errors.New("Only the admins listed in FILEVAULT_ADMINS can repair the vault")
+)
+
+// blobKeyPattern matches the keys blobs are stored under, SHA-256 digests
+// in hex.
+var blobKeyPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// partKeyPattern matches the keys of parts stored by uploads in progress,
+// capturing the upload session they belong to.
+var partKeyPattern = regexp.MustCompile(`^([0-9a-f-]{36})\.part[0-9]+$`)
+
+// fsckProblem is an inconsistency found by Fsck.
+type fsckProblem struct {
+	description string
+	// repair fixes the problem, nil when it can't be fixed automatically
+	repair func() error
+}
+
+// fsckVersion is a version and what Fsck found out about its content.
+type fsckVersion struct {
+	id                 int64
+	fileId             string
+	version            int
+	filePath, checksum string
+	encrypted          bool
+	codec              string
+	blobKey            string
+	missing            bool
+}
+
+// fsckFile is a files row and its versions, newest first.
+type fsckFile struct {
+	id, name, ownerId  string
+	filePath, checksum string
+	encrypted          bool
+	codec              string
+	versions           []*fsckVersion
+}
+
+// Fsck checks the whole vault and prints the problems found, repairing them
+// when asked to. Repairs touch every user's files, so only admins may
+// repair, and nobody can until FILEVAULT_ADMINS is set. Until then any user
+// may check; once it is, only admins can.
+func (s *FileService) Fsck(repair bool) error {
+	userId, err := s.currentUserID()
+	if err != nil {
+		return err
+	}
+	admin, err := s.isAdmin(userId)
+	if err != nil {
+		return err
+	}
+	switch {
+	case repair && !admin:
+		return ErrFsckRepairNotAdmin
+	case len(s.config.Admins) > 0 && !admin:
+		return ErrFsckNotAdmin
+	}
+
+	stored := map[string]int64{}
+	parts := map[string]string{}
+	err = s.store.List(func(key string, size int64) error {
+		// Buckets may hold other objects, only blob keys are considered
+		if blobKeyPattern.MatchString(key) {
+			stored[key] = size
+		} else if match := partKeyPattern.FindStringSubmatch(key); match != nil {
+			parts[key] = match[1]
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list stored blobs: %w", err)
+	}
+	blobRefs, err := s.blobRecords()
+	if err != nil {
+		return err
+	}
+	files, err := s.fsckFiles()
+	if err != nil {
+		return err
+	}
+
+	var problems []fsckProblem
+	report := func(repair func() error, format string, args ...any) {
+		problems = append(problems, fsckProblem{description: fmt.Sprintf(format, args...), repair: repair})
+	}
+
+	// Versions of files that don't exist anymore
+	rows, err := s.db.Query("SELECT id, file_id, version FROM file_versions WHERE file_id NOT IN (SELECT id FROM files)")
+	if err != nil {
+		return fmt.Errorf("failed to query versions: %w", err)
+	}
+	for rows.Next() {
+		var id int64
+		var fileId string
+		var version int
+		if err := rows.Scan(&id, &fileId, &version); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read version: %w", err)
+		}
+		report(func() error {
+			_, err := s.db.Exec("DELETE FROM file_versions WHERE id = ?", id)
+			return err
+		}, "Version %d of file %s, which doesn't exist", version, fileId)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read versions: %w", err)
+	}
+
+	// Content of every version, and the references it holds
+	expectedRefs := map[string]int{}
+	legacyPaths := map[string]bool{}
+	verified := map[string]bool{}
+	versionCount, unverified := 0, 0
+	var dataKey []byte
+	for _, file := range files {
+		if len(file.versions) == 0 {
+			// The files row still says where the content is
+			content := &fsckVersion{fileId: file.id, filePath: file.filePath, checksum: file.checksum, encrypted: file.encrypted, codec: file.codec}
+			s.locateContent(content, blobRefs, stored, legacyPaths)
+			if content.missing {
+				report(func() error {
+					return s.deleteFileRecord(file.id)
+				}, "File %s (%s) has no versions and its content is missing", file.name, file.id)
+				continue
+			}
+			if content.blobKey != "" {
+				expectedRefs[content.blobKey]++
+			}
+			report(func() error {
+				_, err := s.db.Exec(`INSERT INTO file_versions (file_id, version, size, file_path, checksum, mime_type, encrypted, codec, uploaded_at)
+					SELECT id, 1, size, file_path, checksum, mime_type, encrypted, codec, uploaded_at FROM files WHERE id = ?`, file.id)
+				return err
+			}, "File %s (%s) has no versions", file.name, file.id)
+		}
+
+		for _, version := range file.versions {
+			versionCount++
+			s.locateContent(version, blobRefs, stored, legacyPaths)
+			if version.missing {
+				report(s.dropVersionRepair(file, version), "Content of version %d of %s (%s) is missing", version.version, file.name, file.id)
+				continue
+			}
+			if version.blobKey != "" {
+				expectedRefs[version.blobKey]++
+			}
+
+			// Shared blobs are only verified once
+			verifyKey := version.blobKey
+			if verifyKey == "" {
+				verifyKey = version.filePath
+			}
+			if version.checksum == "" || verified[verifyKey] {
+				continue
+			}
+			var key []byte
+			if version.encrypted {
+				// Only the content of the user's own files can be decrypted
+				if file.ownerId != userId {
+					unverified++
+					continue
+				}
+				if dataKey == nil {
+					if dataKey, err = s.dataKey(); err != nil {
+						return err
+					}
+				}
+				key = contentKey(dataKey, version.checksum)
+			}
+			verified[verifyKey] = true
+			if err := s.verifyContent(version, key); err != nil {
+				report(nil, "Content of version %d of %s (%s) doesn't match its checksum: %v", version.version, file.name, file.id, err)
+			}
+		}
+	}
+
+	// Files rows that don't mirror their newest version
+	for _, file := range files {
+		var newest *fsckVersion
+		for _, version := range file.versions {
+			if !version.missing {
+				newest = version
+				break
+			}
+		}
+		switch {
+		case newest == nil:
+			// Reported with its versions, and removed along with the last of them
+		case newest == file.versions[0] && (newest.filePath != file.filePath || newest.checksum != file.checksum || newest.codec != file.codec):
+			report(func() error {
+				return s.mirrorVersion(file, newest.id)
+			}, "File %s (%s) doesn't match its newest version", file.name, file.id)
+		}
+	}
+
+	// Reference counts of the blobs table
+	for _, hash := range slices.Sorted(maps.Keys(blobRefs)) {
+		refCount := blobRefs[hash]
+		switch expected := expectedRefs[hash]; {
+		case expected == 0:
+			report(func() error {
+				_, err := s.db.Exec("DELETE FROM blobs WHERE hash = ?", hash)
+				return err
+			}, "Blob %s is recorded but no version uses it", hash)
+		case expected != refCount:
+			report(func() error {
+				_, err := s.db.Exec("UPDATE blobs SET ref_count = ? WHERE hash = ?", expected, hash)
+				return err
+			}, "Blob %s records %d references but %d versions use it", hash, refCount, expected)
+		}
+	}
+	for _, hash := range slices.Sorted(maps.Keys(expectedRefs)) {
+		expected := expectedRefs[hash]
+		if _, ok := blobRefs[hash]; ok {
+			continue
+		}
+		size := stored[hash]
+		report(func() error {
+			_, err := s.db.Exec("INSERT INTO blobs (hash, size, ref_count) VALUES (?, ?, ?)", hash, size, expected)
+			return err
+		}, "Blob %s is used by %d version(s) but isn't recorded", hash, expected)
+	}
+
+	// Stored content nothing references
+	for _, key := range slices.Sorted(maps.Keys(stored)) {
+		size := stored[key]
+		if expectedRefs[key] > 0 {
+			continue
+		}
+		report(func() error {
+			return s.quarantineBlob(key)
+		}, "Blob %s (%s) is stored but no version uses it", key, utils.GetSizeField(size))
+	}
+	for _, key := range slices.Sorted(maps.Keys(parts)) {
+		var sessions int
+		if err := s.db.QueryRow("SELECT COUNT(*) FROM upload_sessions WHERE id = ?", parts[key]).Scan(&sessions); err != nil {
+			return fmt.Errorf("failed to query uploads: %w", err)
+		}
+		if sessions == 0 {
+			report(func() error {
+				return s.quarantineBlob(key)
+			}, "Part %s belongs to an upload that no longer exists", key)
+		}
+	}
+	if local, ok := s.store.(*LocalBlobStore); ok {
+		strays, err := local.Strays()
+		if err != nil {
+			return fmt.Errorf("failed to list stored files: %w", err)
+		}
+		for _, path := range strays {
+			if absPath, err := filepath.Abs(path); err == nil && legacyPaths[absPath] {
+				continue
+			}
+			report(func() error {
+				return s.quarantineFile(path)
+			}, "%s isn't used by any file", path)
+		}
+	}
+
+	// Rows of other tables left behind by deleted files
+	for _, table := range []struct{ name, description string }{
+		{"file_grants", "share grant(s)"},
+		{"file_tags", "tag(s)"},
+		{"file_attributes", "attribute(s)"},
+	} {
+		var count int
+		err := s.db.QueryRow("SELECT COUNT(*) FROM " + table.name + " WHERE file_id NOT IN (SELECT id FROM files)").Scan(&count)
+		if err != nil {
+			return fmt.Errorf("failed to query %s: %w", table.name, err)
+		}
+		if count > 0 {
+			report(func() error {
+				_, err := s.db.Exec("DELETE FROM " + table.name + " WHERE file_id NOT IN (SELECT id FROM files)")
+				return err
+			}, "%d %s of files that don't exist", count, table.description)
+		}
+	}
+
+	if _, err := os.Stat(DefaultMetadataPath); err == nil {
+		report(nil, "%s hasn't been imported. Run vault import to add its files", DefaultMetadataPath)
+	}
+
+	fmt.Printf("Checked %d files, %d versions and %d stored blobs\n", len(files), versionCount, len(stored))
+	if unverified > 0 {
+		fmt.Printf("%d encrypted version(s) of other users couldn't be verified without their keys\n", unverified)
+	}
+	if len(problems) == 0 {
+		fmt.Println("No problems found")
+		return nil
+	}
+
+	repaired := 0
+	for _, problem := range problems {
+		switch {
+		case !repair:
+			fmt.Printf("  %s\n", problem.description)
+		case problem.repair == nil:
+			fmt.Printf("  %s (can't be repaired automatically)\n", problem.description)
+		default:
+			if err := problem.repair(); err != nil {
+				fmt.Printf("  %s (repair failed: %v)\n", problem.description, err)
+				continue
+			}
+			fmt.Printf("  %s (repaired)\n", problem.description)
+			repaired++
+		}
+	}
+	if repair {
+		fmt.Printf("Repaired %d of %d problem(s)\n", repaired, len(problems))
+	} else if admin {
+		fmt.Printf("Found %d problem(s). Run vault fsck --repair to fix them\n", len(problems))
+	} else {
+		fmt.Printf("Found %d problem(s). An admin can fix them with vault fsck --repair\n", len(problems))
+	}
+	return nil
+}
+
+// blobRecords returns the reference counts of the blobs table by hash.
+func (s *FileService) blobRecords() (map[string]int, error) {
+	rows, err := s.db.Query("SELECT hash, ref_count FROM blobs")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blobs: %w", err)
+	}
+	defer rows.Close()
+
+	refs := map[string]int{}
+	for rows.Next() {
+		var hash string
+		var refCount int
+		if err := rows.Scan(&hash, &refCount); err != nil {
+			return nil, fmt.Errorf("failed to read blob: %w", err)
+		}
+		refs[hash] = refCount
+	}
+	return refs, rows.Err()
+}
+
+// fsckFiles returns every file, live or trashed, with its versions.
+func (s *FileService) fsckFiles() ([]*fsckFile, error) {
+	rows, err := s.db.Query("SELECT id, file_name, user_id, file_path, checksum, encrypted, codec FROM files ORDER BY uploaded_at")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query files: %w", err)
+	}
+	var files []*fsckFile
+	byId := map[string]*fsckFile{}
+	for rows.Next() {
+		var file fsckFile
+		var checksum sql.NullString
+		if err := rows.Scan(&file.id, &file.name, &file.ownerId, &file.filePath, &checksum, &file.encrypted, &file.codec); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		file.checksum = checksum.String
+		files = append(files, &file)
+		byId[file.id] = &file
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read files: %w", err)
+	}
+
+	rows, err = s.db.Query("SELECT id, file_id, version, file_path, checksum, encrypted, codec FROM file_versions ORDER BY file_id, version DESC")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query versions: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version fsckVersion
+		var filePath, checksum sql.NullString
+		if err := rows.Scan(&version.id, &version.fileId, &version.version, &filePath, &checksum, &version.encrypted, &version.codec); err != nil {
+			return nil, fmt.Errorf("failed to read version: %w", err)
+		}
+		version.filePath, version.checksum = filePath.String, checksum.String
+		if file, ok := byId[version.fileId]; ok {
+			file.versions = append(file.versions, &version)
+		}
+	}
+	return files, rows.Err()
+}
+
+// locateContent works out where the content of a version is stored and
+// whether it's there. Unlike storedBlobKey it doesn't rely on the blob being
+// recorded, so versions whose record went missing are still matched with
+// their content. Paths of files stored before blobs existed are collected in
+// legacyPaths.
+func (s *FileService) locateContent(version *fsckVersion, blobRefs map[string]int, stored map[string]int64, legacyPaths map[string]bool) {
+	version.blobKey = ""
+	for _, key := range []string{version.filePath, version.checksum} {
+		if _, ok := blobRefs[key]; ok && key != "" {
+			version.blobKey = key
+			break
+		}
+	}
+	if version.blobKey == "" && blobKeyPattern.MatchString(version.filePath) {
+		version.blobKey = version.filePath
+	}
+	if _, ok := stored[version.checksum]; version.blobKey == "" && ok && !version.encrypted {
+		version.blobKey = version.checksum
+	}
+
+	if version.blobKey != "" {
+		_, ok := stored[version.blobKey]
+		version.missing = !ok
+		return
+	}
+	if absPath, err := filepath.Abs(version.filePath); err == nil {
+		legacyPaths[absPath] = true
+	}
+	_, err := os.Stat(version.filePath)
+	version.missing = err != nil
+}
+
+// verifyContent reads the content of a version and compares its digest with
+// the recorded checksum. Encrypted content is decrypted with key, which also
+// authenticates it, and compressed content decompressed.
+func (s *FileService) verifyContent(version *fsckVersion, key []byte) error {
+	var content io.ReadCloser
+	var err error
+	if version.blobKey == "" {
+		content, err = os.Open(version.filePath)
+	} else {
+		content, err = s.store.Get(version.blobKey)
+	}
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	var plaintext io.Reader = content
+	if version.encrypted {
+		if plaintext, err = newDecryptReader(key, content); err != nil {
+			return err
+		}
+	}
+	if plaintext, err = newDecompressReader(version.codec, plaintext); err != nil {
+		return err
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, plaintext); err != nil {
+		return err
+	}
+	if hex.EncodeToString(hasher.Sum(nil)) != version.checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// dropVersionRepair returns the repair of a version whose content is gone.
+// The version is removed, and the file along with it when it was the last
+// one. The blob counts are recounted separately.
+func (s *FileService) dropVersionRepair(file *fsckFile, version *fsckVersion) func() error {
+	return func() error {
+		if _, err := s.db.Exec("DELETE FROM file_versions WHERE id = ?", version.id); err != nil {
+			return err
+		}
+		var newestId int64
+		var newestVersion int
+		err := s.db.QueryRow("SELECT id, version FROM file_versions WHERE file_id = ? ORDER BY version DESC LIMIT 1", file.id).Scan(&newestId, &newestVersion)
+		if err == sql.ErrNoRows {
+			return s.deleteFileRecord(file.id)
+		}
+		if err != nil {
+			return err
+		}
+		if version.version > newestVersion {
+			return s.mirrorVersion(file, newestId)
+		}
+		return nil
+	}
+}
+
+// mirrorVersion points a files row at the content of one of its versions.
+func (s *FileService) mirrorVersion(file *fsckFile, versionId int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`UPDATE files SET (size, file_path, checksum, mime_type, encrypted, codec, uploaded_at) =
+		(SELECT size, file_path, checksum, mime_type, encrypted, codec, uploaded_at FROM file_versions WHERE id = ?)
+		WHERE id = ?`, versionId, file.id)
+	if err != nil {
+		return err
+	}
+	// Grants are sealed with the owner's data key, only the owner can reseal them
+	if userId, err := s.currentUserID(); err == nil && userId == file.ownerId {
+		var checksum sql.NullString
+		var encrypted bool
+		if err := tx.QueryRow("SELECT checksum, encrypted FROM files WHERE id = ?", file.id).Scan(&checksum, &encrypted); err != nil {
+			return err
+		}
+		if err := s.resealGrants(tx, file.id, checksum.String, encrypted); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// quarantineBlob moves a blob out of the store into the quarantine
+// directory.
+func (s *FileService) quarantineBlob(key string) error {
+	blob, err := s.store.Get(key)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	if err := os.MkdirAll(s.config.QuarantinePath, os.ModePerm); err != nil {
+		return err
+	}
+	quarantined, err := os.CreateTemp(s.config.QuarantinePath, ".blob-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(quarantined.Name())
+	_, err = io.Copy(quarantined, blob)
+	if closeErr := quarantined.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(quarantined.Name(), filepath.Join(s.config.QuarantinePath, key)); err != nil {
+		return err
+	}
+	return s.store.Delete(key)
+}
+
+// quarantineFile moves a file of the local store into the quarantine
+// directory.
+func (s *FileService) quarantineFile(path string) error {
+	if err := os.MkdirAll(s.config.QuarantinePath, os.ModePerm); err != nil {
+		return err
+	}
+	destination := filepath.Join(s.config.QuarantinePath, filepath.Base(path))
+	if _, err := os.Stat(destination); err == nil {
+		return fmt.Errorf("%s is already quarantined", destination)
+	}
+	return os.Rename(path, destination)
+}
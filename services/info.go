@@ -0,0 +1,157 @@
+package services
+
+import (
+	"database/sql"
+	"filevault/utils"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// detectMimeType sniffs the MIME type of a file from the start of its
+// content. Formats sniffing can't tell apart from plain text or arbitrary
+// bytes fall back to the type of the file's extension. The reader is
+// rewound afterwards.
+func detectMimeType(fileName string, r io.ReadSeeker) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	mimeType := http.DetectContentType(head[:n])
+	if mimeType == "application/octet-stream" || strings.HasPrefix(mimeType, "text/plain") {
+		if byName := mime.TypeByExtension(filepath.Ext(fileName)); byName != "" {
+			return byName, nil
+		}
+	}
+	return mimeType, nil
+}
+
+// FileInfo prints everything known about a file the user can read. Its
+// folder, tags and attributes are the owner's and only shown to them.
+func (s *FileService) FileInfo(fileId string) error {
+	access, err := s.authorize(fileId, actionRead)
+	if err != nil {
+		return err
+	}
+
+	var ownerEmail, folderId, mimeType sql.NullString
+	var size, storedSize int64
+	var uploadedAt time.Time
+	err = s.db.QueryRow(`SELECT u.email, files.folder_id, files.mime_type, files.size, `+storedSizeColumn+`, files.uploaded_at
+        FROM files LEFT JOIN users u ON u.id = files.user_id WHERE files.id = ?`, fileId).
+		Scan(&ownerEmail, &folderId, &mimeType, &size, &storedSize, &uploadedAt)
+	if err != nil {
+		return fmt.Errorf("failed to query file: %w", err)
+	}
+	var versions int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM file_versions WHERE file_id = ?", fileId).Scan(&versions); err != nil {
+		return fmt.Errorf("failed to query versions: %w", err)
+	}
+	sharing, err := s.sharingState(access)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Name:       %s\n", access.fileName)
+	fmt.Printf("ID:         %s\n", fileId)
+	fmt.Printf("Owner:      %s\n", valueOr(ownerEmail.String, "unknown"))
+	fmt.Printf("Size:       %s (%d bytes)\n", utils.GetSizeField(size), size)
+	fmt.Printf("Stored:     %s (%d bytes, %s)\n", utils.GetSizeField(storedSize), storedSize, storageFormat(access))
+	fmt.Printf("Type:       %s\n", valueOr(mimeType.String, "unknown"))
+	fmt.Printf("SHA-256:    %s\n", valueOr(access.checksum, "not recorded"))
+	fmt.Printf("Uploaded:   %s\n", uploadedAt.Local().Format(time.DateTime))
+	fmt.Printf("Versions:   %d\n", versions)
+	fmt.Printf("Sharing:    %s\n", sharing)
+	if access.isOwner() {
+		return s.printOwnerInfo(fileId, folderId.String)
+	}
+	return nil
+}
+
+// printOwnerInfo prints what only the owner of a file sees of it: how they
+// organized it in their folders, tags and attributes.
+func (s *FileService) printOwnerInfo(fileId, folderId string) error {
+	folder, err := s.folderPath(folderId)
+	if err != nil {
+		return err
+	}
+	tags, err := fileTags(s.db, fileId)
+	if err != nil {
+		return err
+	}
+	attributes, err := fileAttributes(s.db, fileId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Folder:     %s\n", folder)
+	fmt.Printf("Tags:       %s\n", valueOr(strings.Join(tags, ", "), "none"))
+	if len(attributes) == 0 {
+		fmt.Println("Attributes: none")
+	} else {
+		pairs := make([]string, len(attributes))
+		for i, attribute := range attributes {
+			pairs[i] = attribute[0] + "=" + attribute[1]
+		}
+		fmt.Printf("Attributes: %s\n", strings.Join(pairs, ", "))
+	}
+	return nil
+}
+
+// sharingState describes who a file is shared with. Grantees only learn
+// about their own access.
+func (s *FileService) sharingState(access *fileAccess) (string, error) {
+	if !access.isOwner() {
+		return fmt.Sprintf("shared with you (%s access)", access.permission), nil
+	}
+
+	rows, err := s.db.Query("SELECT u.email, g.permission FROM file_grants g JOIN users u ON u.id = g.user_id WHERE g.file_id = ? ORDER BY u.email", access.fileId)
+	if err != nil {
+		return "", fmt.Errorf("failed to query grants: %w", err)
+	}
+	defer rows.Close()
+	var grants []string
+	for rows.Next() {
+		var email, permission string
+		if err := rows.Scan(&email, &permission); err != nil {
+			return "", fmt.Errorf("failed to read grant: %w", err)
+		}
+		grants = append(grants, fmt.Sprintf("%s (%s)", email, permission))
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("failed to read grants: %w", err)
+	}
+	if len(grants) == 0 {
+		return "not shared", nil
+	}
+	return "shared with " + strings.Join(grants, ", "), nil
+}
+
+// storageFormat describes how the content of a file is stored.
+func storageFormat(access *fileAccess) string {
+	format := "as is"
+	if access.encrypted {
+		format = "encrypted"
+	}
+	if access.codec != "" {
+		format = access.codec + " compressed, " + format
+	}
+	return format
+}
+
+// valueOr returns value, or fallback when it's empty.
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
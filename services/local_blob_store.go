@@ -0,0 +1,150 @@
+package services
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalBlobStore keeps blobs as files on the local disk.
+// Blobs are fanned out into subdirectories named after the first two
+// characters of their key to keep directories small.
+type LocalBlobStore struct {
+	root string
+}
+
+func NewLocalBlobStore(root string) *LocalBlobStore {
+	return &LocalBlobStore{
+		root: root,
+	}
+}
+
+func (s *LocalBlobStore) path(key string) string {
+	return filepath.Join(s.root, key[:2], key)
+}
+
+func (s *LocalBlobStore) Put(key string, r io.Reader, size int64) error {
+	destinationPath := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(destinationPath), os.ModePerm); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see a partial blob,
+	// and flush it to disk before it's renamed so a crash can't leave a
+	// truncated blob under its final name
+	tempFile, err := os.CreateTemp(s.root, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+
+	_, err = io.Copy(tempFile, r)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tempFile.Name(), destinationPath); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(destinationPath))
+}
+
+// syncDir flushes a directory to disk, making a rename into it durable.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func (s *LocalBlobStore) Compose(key string, parts []string, size int64) error {
+	return composeByCopy(s, key, parts, size)
+}
+
+func (s *LocalBlobStore) Get(key string) (io.ReadCloser, error) {
+	return os.Open(s.path(key))
+}
+
+func (s *LocalBlobStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalBlobStore) Exists(key string) (bool, error) {
+	_, err := os.Stat(s.path(key))
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (s *LocalBlobStore) List(fn func(key string, size int64) error) error {
+	dirs, err := os.ReadDir(s.root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(s.root, dir.Name()))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if !entry.Type().IsRegular() || !strings.HasPrefix(entry.Name(), dir.Name()) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			if err := fn(entry.Name(), info.Size()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Strays returns the paths of files under the root that aren't blobs, such
+// as leftovers of interrupted uploads or files older builds stored there.
+func (s *LocalBlobStore) Strays() ([]string, error) {
+	var strays []string
+	err := filepath.WalkDir(s.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == s.root {
+				return nil
+			}
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		dir := filepath.Base(filepath.Dir(path))
+		inFanOut := filepath.Dir(filepath.Dir(path)) == filepath.Clean(s.root) && len(dir) == 2
+		if !inFanOut || !strings.HasPrefix(entry.Name(), dir) {
+			strays = append(strays, path)
+		}
+		return nil
+	})
+	return strays, err
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+)
+
+// File metadata lives in the SQLite files table. Before that it was kept in
+// storage/metadata.json, which can be imported once and is otherwise only
+// written as an export format.
+
+// DefaultMetadataPath is where metadata.json used to be kept.
+const DefaultMetadataPath = "./storage/metadata.json"
+
+// ImportMetadata migrates a metadata.json file into the files table. The
+// JSON file doesn't record owners, so imported files belong to the logged in
+// user. Entries the database already has are skipped. The file is renamed
+// afterwards so it can't be imported twice.
+func (s *FileService) ImportMetadata(path string) error {
+	userId, err := s.currentUserID()
+	if err != nil {
+		return err
+	}
+
+	fileContent, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var metadataList []FileMetadata
+	// Older builds wrote either a list or a single object
+	if err := json.Unmarshal(fileContent, &metadataList); err != nil {
+		var singleMetadata FileMetadata
+		if err := json.Unmarshal(fileContent, &singleMetadata); err != nil {
+			return ErrJSONUnmarshal
+		}
+		metadataList = []FileMetadata{singleMetadata}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	imported := 0
+	for _, entry := range metadataList {
+		var known int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM files WHERE id = ?", entry.FileId).Scan(&known); err != nil {
+			return fmt.Errorf("failed to query files: %w", err)
+		}
+		if known > 0 {
+			continue
+		}
+		var taken int
+		err := tx.QueryRow("SELECT COUNT(*) FROM files WHERE user_id = ? AND folder_id IS NULL AND file_name = ? AND deleted_at IS NULL", userId, entry.FileName).Scan(&taken)
+		if err != nil {
+			return fmt.Errorf("failed to query files: %w", err)
+		}
+		if taken > 0 {
+			fmt.Printf("Skipping %s (%s): you already have a file with this name\n", entry.FileId, entry.FileName)
+			continue
+		}
+
+		// The content isn't read, so the type can only be guessed from the name
+		mimeType := mime.TypeByExtension(filepath.Ext(entry.FileName))
+		_, err = tx.Exec("INSERT INTO files (id, file_name, user_id, size, file_path, checksum, mime_type, encrypted, uploaded_at) VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?)",
+			entry.FileId, entry.FileName, userId, entry.Size, entry.Path, entry.Checksum, mimeType, entry.UploadedAt)
+		if err != nil {
+			return fmt.Errorf("failed to import %s: %w", entry.FileId, err)
+		}
+		if _, err := addVersion(tx, entry.FileId, entry.Size, entry.Path, entry.Checksum, mimeType, false, "", entry.UploadedAt); err != nil {
+			return fmt.Errorf("failed to import %s: %w", entry.FileId, err)
+		}
+		imported++
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit import: %w", err)
+	}
+
+	if err := os.Rename(path, path+".imported"); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d of %d entries from %s\n", imported, len(metadataList), path)
+	return nil
+}
+
+// ExportMetadata writes the metadata of the user's files to a JSON file in
+// the format metadata.json used.
+func (s *FileService) ExportMetadata(path string) error {
+	userId, err := s.currentUserID()
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.db.Query("SELECT id, file_name, size, file_path, checksum, uploaded_at FROM files WHERE user_id = ? AND deleted_at IS NULL ORDER BY uploaded_at", userId)
+	if err != nil {
+		return fmt.Errorf("failed to query files: %w", err)
+	}
+	defer rows.Close()
+
+	metadataList := []FileMetadata{}
+	for rows.Next() {
+		var entry FileMetadata
+		var checksum sql.NullString
+		if err := rows.Scan(&entry.FileId, &entry.FileName, &entry.Size, &entry.Path, &checksum, &entry.UploadedAt); err != nil {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+		entry.Checksum = checksum.String
+		metadataList = append(metadataList, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read files: %w", err)
+	}
+
+	exported, err := json.MarshalIndent(metadataList, "", "  ")
+	if err != nil {
+		return ErrMetadataJSONMarshal
+	}
+	if err := os.WriteFile(path, exported, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Exported metadata of %d files to %s\n", len(metadataList), path)
+	return nil
+}
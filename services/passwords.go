@@ -0,0 +1,116 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Passwords are hashed with Argon2id and a random salt per user, and stored
+// in the PHC string format, e.g.
+//
+//	$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+//
+// The format records the parameters, so they can be raised later without
+// breaking existing hashes. Users registered before this have a hex encoded
+// PBKDF2 hash with a fixed salt; it's replaced the next time they log in.
+
+var (
+	ErrInvalidPassword     = errors.New("invalid password")
+	ErrInvalidPasswordHash = errors.New("Stored password hash is invalid")
+)
+
+const (
+	// Argon2id parameters for new password hashes
+	passwordHashTime    = 3
+	passwordHashMemory  = 64 * 1024
+	passwordHashThreads = 4
+	passwordHashSize    = 32
+	passwordSaltSize    = 16
+
+	// Parameters of the legacy PBKDF2 hashes
+	legacyPasswordSalt       = "salt"
+	legacyPasswordIterations = 1000
+)
+
+// passwordHashParams are the Argon2id parameters recorded in a hash.
+type passwordHashParams struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+// currentPasswordParams are the parameters new hashes are made with.
+var currentPasswordParams = passwordHashParams{
+	time:    passwordHashTime,
+	memory:  passwordHashMemory,
+	threads: passwordHashThreads,
+}
+
+// hashPassword hashes a password with a new random salt.
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	p := currentPasswordParams
+	hash := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, passwordHashSize)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+// verifyPassword checks a password against a stored hash in constant time.
+// It also reports whether the hash should be replaced by a new one, because
+// it's a legacy hash or made with weaker parameters than current ones.
+func verifyPassword(password, stored string) (ok, rehash bool, err error) {
+	if !strings.HasPrefix(stored, "$") {
+		// Legacy PBKDF2 hash
+		want, err := hex.DecodeString(stored)
+		if err != nil || len(want) != sha256.Size {
+			return false, false, ErrInvalidPasswordHash
+		}
+		hash := pbkdf2.Key([]byte(password), []byte(legacyPasswordSalt), legacyPasswordIterations, sha256.Size, sha256.New)
+		return subtle.ConstantTimeCompare(hash, want) == 1, true, nil
+	}
+
+	params, salt, want, err := parsePasswordHash(stored)
+	if err != nil {
+		return false, false, err
+	}
+	hash := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(hash, want) == 1, params != currentPasswordParams, nil
+}
+
+// parsePasswordHash splits an Argon2id hash in the PHC string format into
+// its parameters, salt and hash.
+func parsePasswordHash(stored string) (params passwordHashParams, salt, hash []byte, err error) {
+	fields := strings.Split(stored, "$")
+	if len(fields) != 6 || fields[0] != "" || fields[1] != "argon2id" {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(fields[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	if _, err := fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(fields[4]); err != nil {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	if hash, err = base64.RawStdEncoding.DecodeString(fields[5]); err != nil || len(hash) == 0 {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	if params.time == 0 || params.threads == 0 {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	return params, salt, hash, nil
+}
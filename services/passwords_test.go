@@ -0,0 +1,122 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// phcHash formats an Argon2id hash of password like hashPassword does, with
+// the given parameters and salt.
+func phcHash(password string, params passwordHashParams, salt []byte) string {
+	hash := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, passwordHashSize)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.memory, params.time, params.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash))
+}
+
+func TestVerifyPassword(t *testing.T) {
+	current, err := hashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := hex.EncodeToString(pbkdf2.Key([]byte("hunter2"), []byte(legacyPasswordSalt), legacyPasswordIterations, sha256.Size, sha256.New))
+	weaker := phcHash("hunter2", passwordHashParams{time: 1, memory: 8 * 1024, threads: 1}, []byte("0123456789abcdef"))
+
+	tests := []struct {
+		name       string
+		password   string
+		stored     string
+		wantOk     bool
+		wantRehash bool
+	}{
+		{"current hash", "hunter2", current, true, false},
+		{"current hash, wrong password", "hunter3", current, false, false},
+		{"legacy hash", "hunter2", legacy, true, true},
+		{"legacy hash, wrong password", "hunter3", legacy, false, true},
+		{"weaker parameters", "hunter2", weaker, true, true},
+		{"weaker parameters, wrong password", "hunter3", weaker, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ok, rehash, err := verifyPassword(test.password, test.stored)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ok != test.wantOk || rehash != test.wantRehash {
+				t.Errorf("got ok=%v rehash=%v, want ok=%v rehash=%v", ok, rehash, test.wantOk, test.wantRehash)
+			}
+		})
+	}
+}
+
+func TestHashPasswordSaltsEachHash(t *testing.T) {
+	first, err := hashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := hashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Error("hashing the same password twice gave the same hash")
+	}
+}
+
+func TestParsePasswordHash(t *testing.T) {
+	current, err := hashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, salt, hash, err := parsePasswordHash(current)
+	if err != nil {
+		t.Fatalf("parsing a new hash: %v", err)
+	}
+	if params != currentPasswordParams || len(salt) != passwordSaltSize || len(hash) != passwordHashSize {
+		t.Errorf("got params %+v, %d byte salt and %d byte hash", params, len(salt), len(hash))
+	}
+
+	const salt64, hash64 = "MDEyMzQ1Njc4OWFiY2RlZg", "aGFzaGhhc2hoYXNoaGFzaA"
+	tests := []struct {
+		name   string
+		stored string
+	}{
+		{"empty", ""},
+		{"too few fields", "$argon2id$v=19$m=65536,t=3,p=4$" + salt64},
+		{"too many fields", "$argon2id$v=19$m=65536,t=3,p=4$" + salt64 + "$" + hash64 + "$extra"},
+		{"no leading $", "argon2id$v=19$m=65536,t=3,p=4$" + salt64 + "$" + hash64 + "$"},
+		{"other algorithm", "$argon2i$v=19$m=65536,t=3,p=4$" + salt64 + "$" + hash64},
+		{"bad version", "$argon2id$v=16$m=65536,t=3,p=4$" + salt64 + "$" + hash64},
+		{"missing version", "$argon2id$version$m=65536,t=3,p=4$" + salt64 + "$" + hash64},
+		{"bad parameters", "$argon2id$v=19$m=lots,t=3,p=4$" + salt64 + "$" + hash64},
+		{"bad salt base64", "$argon2id$v=19$m=65536,t=3,p=4$not*base64$" + hash64},
+		{"bad hash base64", "$argon2id$v=19$m=65536,t=3,p=4$" + salt64 + "$not*base64"},
+		{"empty hash", "$argon2id$v=19$m=65536,t=3,p=4$" + salt64 + "$"},
+		{"zero time", "$argon2id$v=19$m=65536,t=0,p=4$" + salt64 + "$" + hash64},
+		{"zero threads", "$argon2id$v=19$m=65536,t=3,p=0$" + salt64 + "$" + hash64},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, _, err := parsePasswordHash(test.stored); !errors.Is(err, ErrInvalidPasswordHash) {
+				t.Errorf("got error %v, want ErrInvalidPasswordHash", err)
+			}
+			if _, _, err := verifyPassword("hunter2", test.stored); !errors.Is(err, ErrInvalidPasswordHash) {
+				t.Errorf("verifyPassword got error %v, want ErrInvalidPasswordHash", err)
+			}
+		})
+	}
+}
+
+func TestVerifyPasswordRejectsMalformedLegacyHash(t *testing.T) {
+	for _, stored := range []string{"abc", "not hex at all", hex.EncodeToString([]byte("too short"))} {
+		if _, _, err := verifyPassword("hunter2", stored); !errors.Is(err, ErrInvalidPasswordHash) {
+			t.Errorf("%q: got error %v, want ErrInvalidPasswordHash", stored, err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package services
+
+import "io"
+
+// Transfers report how far along they are to a ProgressReporter, which the
+// CLI sets to draw progress bars. Without one transfers are silent.
+
+// ProgressReporter is told about the transfers FileService runs.
+type ProgressReporter interface {
+	// Track starts reporting a transfer of total bytes. total is 0 when the
+	// size isn't known.
+	Track(description string, total int64) Transfer
+}
+
+// Transfer is the progress of a single transfer.
+type Transfer interface {
+	// Add records n more bytes transferred.
+	Add(n int64)
+	// Finish is called once the transfer ended, whether or not it succeeded.
+	Finish()
+}
+
+// SetProgressReporter makes transfers report their progress to reporter.
+func (s *FileService) SetProgressReporter(reporter ProgressReporter) {
+	s.progress = reporter
+}
+
+// track starts a transfer on the progress reporter, if there is one.
+func (s *FileService) track(description string, total int64) Transfer {
+	if s.progress == nil {
+		return silentTransfer{}
+	}
+	return s.progress.Track(description, total)
+}
+
+type silentTransfer struct{}
+
+func (silentTransfer) Add(int64) {}
+func (silentTransfer) Finish()   {}
+
+// progressReader reports the bytes read through it to a transfer.
+type progressReader struct {
+	io.Reader
+	transfer Transfer
+}
+
+func (r progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.transfer.Add(int64(n))
+	return n, err
+}
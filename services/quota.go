@@ -0,0 +1,148 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"filevault/utils"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Every user gets the configured default quota unless an admin has set an
+// override in users.quota_bytes. Usage is the size of every distinct content
+// the user's files and their older versions keep stored, including files in
+// the trash. Content stored under several names or versions counts once. A
+// quota of 0 is unlimited.
+
+var (
+	ErrQuotaExceeded = errors.New("Upload would exceed your storage quota")
+	ErrNotAdmin      = errors.New("Only admins can change quotas")
+	ErrUserNotFound  = errors.New("User doesn't exist")
+)
+
+// quotaUsage returns the bytes a user stores and the quota they're allowed.
+func (s *FileService) quotaUsage(userId string) (used int64, quota int64, err error) {
+	err = s.db.QueryRow(`SELECT COALESCE(SUM(size), 0) FROM (
+            SELECT MAX(size) AS size FROM (
+                SELECT file_path, size FROM files WHERE user_id = ?
+                UNION ALL
+                SELECT v.file_path, v.size FROM file_versions v JOIN files f ON f.id = v.file_id WHERE f.user_id = ?
+            ) GROUP BY file_path
+        )`, userId, userId).Scan(&used)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to compute storage usage: %w", err)
+	}
+
+	var override sql.NullInt64
+	err = s.db.QueryRow("SELECT quota_bytes FROM users WHERE id = ?", userId).Scan(&override)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to query quota: %w", err)
+	}
+	if override.Valid {
+		return used, override.Int64, nil
+	}
+	return used, s.config.DefaultQuota, nil
+}
+
+// checkQuota fails when storing size bytes would take the user over their
+// quota. Content already stored under blobKey takes no more space, the key
+// is empty when it isn't known yet.
+func (s *FileService) checkQuota(userId string, size int64, blobKey string) error {
+	used, quota, err := s.quotaUsage(userId)
+	if err != nil {
+		return err
+	}
+	if quota == 0 {
+		return nil
+	}
+
+	if blobKey != "" {
+		var stored bool
+		err = s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM files WHERE user_id = ? AND file_path = ?)
+            OR EXISTS (SELECT 1 FROM file_versions v JOIN files f ON f.id = v.file_id WHERE f.user_id = ? AND v.file_path = ?)`,
+			userId, blobKey, userId, blobKey).Scan(&stored)
+		if err != nil {
+			return fmt.Errorf("failed to compute storage usage: %w", err)
+		}
+		if stored {
+			return nil
+		}
+	}
+	if used+size > quota {
+		return fmt.Errorf("%w: %s used of %s, the file needs %s",
+			ErrQuotaExceeded, utils.GetSizeField(used), utils.GetSizeField(quota), utils.GetSizeField(size))
+	}
+	return nil
+}
+
+// ShowQuota prints the user's storage usage and quota.
+func (s *FileService) ShowQuota() error {
+	userId, err := s.currentUserID()
+	if err != nil {
+		return err
+	}
+	used, quota, err := s.quotaUsage(userId)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Used:      %s\n", utils.GetSizeField(used))
+	if quota == 0 {
+		fmt.Println("Quota:     unlimited")
+		return nil
+	}
+	available := max(quota-used, 0)
+	fmt.Printf("Quota:     %s\n", utils.GetSizeField(quota))
+	fmt.Printf("Available: %s (%.1f%% used)\n", utils.GetSizeField(available), float64(used)*100/float64(quota))
+	return nil
+}
+
+// SetQuota overrides the quota of the user with the given email. Passing
+// "default" removes the override. Only admins may change quotas.
+func (s *FileService) SetQuota(email, size string) error {
+	userId, err := s.currentUserID()
+	if err != nil {
+		return err
+	}
+	admin, err := s.isAdmin(userId)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return ErrNotAdmin
+	}
+
+	var quota sql.NullInt64
+	if !strings.EqualFold(size, "default") {
+		bytes, err := utils.ParseSize(size)
+		if err != nil {
+			return err
+		}
+		quota = sql.NullInt64{Int64: bytes, Valid: true}
+	}
+
+	result, err := s.db.Exec("UPDATE users SET quota_bytes = ? WHERE email = ?", quota, email)
+	if err != nil {
+		return fmt.Errorf("failed to set quota: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrUserNotFound
+	}
+
+	if quota.Valid {
+		fmt.Printf("Quota of %s set to %s\n", email, utils.GetSizeField(quota.Int64))
+	} else {
+		fmt.Printf("Quota of %s reset to the default\n", email)
+	}
+	return nil
+}
+
+// isAdmin reports whether the user is one of the configured admins.
+func (s *FileService) isAdmin(userId string) (bool, error) {
+	var email string
+	if err := s.db.QueryRow("SELECT email FROM users WHERE id = ?", userId).Scan(&email); err != nil {
+		return false, fmt.Errorf("failed to query user: %w", err)
+	}
+	return slices.Contains(s.config.Admins, email), nil
+}
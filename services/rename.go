@@ -0,0 +1,98 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Renaming and moving only change a file's metadata, so its ID, versions,
+// tags and grants stay as they are. Names are unique among the live files
+// of a folder, the same rule uploads follow to decide whether a file is a
+// new version.
+
+var ErrInvalidFileName = errors.New("File names can't be empty, . or .. or contain /")
+
+func validFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.Contains(name, "/")
+}
+
+// RenameFile gives a file a new name within its folder.
+func (s *FileService) RenameFile(fileId, newName string) error {
+	access, err := s.authorize(fileId, actionWrite)
+	if err != nil {
+		return err
+	}
+	if !validFileName(newName) {
+		return ErrInvalidFileName
+	}
+	if newName == access.fileName {
+		return nil
+	}
+
+	if err := s.relocateFile(access, nil, newName); err != nil {
+		return err
+	}
+	fmt.Printf("Renamed %s to %s\n", access.fileName, newName)
+	return nil
+}
+
+// MoveFile moves a file the user owns to the folder at path.
+func (s *FileService) MoveFile(fileId, path string) error {
+	access, err := s.authorize(fileId, actionManage)
+	if err != nil {
+		return err
+	}
+	folderId, err := s.resolveFolder(access.userId, path)
+	if err != nil {
+		return err
+	}
+
+	if err := s.relocateFile(access, &folderId, access.fileName); err != nil {
+		return err
+	}
+	destination, err := s.folderPath(folderId)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Moved %s to %s\n", access.fileName, destination)
+	return nil
+}
+
+// relocateFile changes the name of a file and, unless folderId is nil, its
+// folder, failing when the name is taken there.
+func (s *FileService) relocateFile(access *fileAccess, folderId *string, name string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if folderId == nil {
+		var current sql.NullString
+		if err := tx.QueryRow("SELECT folder_id FROM files WHERE id = ?", access.fileId).Scan(&current); err != nil {
+			return fmt.Errorf("failed to query file: %w", err)
+		}
+		folderId = &current.String
+	}
+	folder := folderArg(*folderId)
+
+	var taken int
+	err = tx.QueryRow("SELECT COUNT(*) FROM files WHERE user_id = ? AND folder_id IS ? AND file_name = ? AND deleted_at IS NULL AND id != ?",
+		access.ownerId, folder, name, access.fileId).Scan(&taken)
+	if err != nil {
+		return fmt.Errorf("failed to query files: %w", err)
+	}
+	if taken > 0 {
+		return fmt.Errorf("%w: %s", ErrNameTaken, name)
+	}
+
+	if _, err := tx.Exec("UPDATE files SET file_name = ?, folder_id = ? WHERE id = ?", name, folder, access.fileId); err != nil {
+		return fmt.Errorf("failed to update file record: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit file record: %w", err)
+	}
+	return nil
+}
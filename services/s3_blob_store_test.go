@@ -0,0 +1,390 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testBucket    = "vault"
+	testRegion    = "us-east-1"
+	testAccessKey = "minio"
+	testSecretKey = "minio-secret"
+	// testListPage is how many objects the stand-in lists per page, small
+	// so listings need continuation tokens
+	testListPage = 2
+)
+
+// s3StandIn is a local stand-in for an S3-compatible store, like MinIO. It
+// checks the signature of every request, keeps objects in memory and lists
+// them a few at a time.
+type s3StandIn struct {
+	t       *testing.T
+	mu      sync.Mutex
+	objects map[string][]byte
+	// tokens are the continuation tokens listings were asked for
+	tokens []string
+	// notFoundDeletes makes deleting a missing object answer 404, like
+	// some S3-compatible stores do
+	notFoundDeletes bool
+	// badSignaturesExpected stops bad signatures from failing the test
+	badSignaturesExpected bool
+}
+
+func newS3StandIn(t *testing.T) (*s3StandIn, *S3BlobStore) {
+	t.Helper()
+	standIn := &s3StandIn{t: t, objects: map[string][]byte{}}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+	store, err := NewS3BlobStore(server.URL+"/", testBucket, testRegion, testAccessKey, testSecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return standIn, store
+}
+
+func (m *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := m.checkSignature(r); err != nil {
+		if !m.badSignaturesExpected {
+			m.t.Errorf("%s %s: %v", r.Method, r.URL, err)
+		}
+		writeS3Error(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+	if r.URL.Path == "/"+testBucket && r.Method == http.MethodGet {
+		m.list(w, r)
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+testBucket+"/")
+	if !ok || key == "" {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	// The body is read before locking, Compose streams it from Gets
+	var body []byte
+	if r.Method == http.MethodPut {
+		// S3 needs the length of uploads up front
+		if slices.Contains(r.TransferEncoding, "chunked") || r.ContentLength < 0 {
+			writeS3Error(w, http.StatusLengthRequired, "MissingContentLength")
+			return
+		}
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil || int64(len(body)) != r.ContentLength {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	content, exists := m.objects[key]
+	switch r.Method {
+	case http.MethodPut:
+		m.objects[key] = body
+	case http.MethodGet, http.MethodHead:
+		if !exists {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		if r.Method == http.MethodGet {
+			w.Write(content)
+		}
+	case http.MethodDelete:
+		if !exists && m.notFoundDeletes {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		delete(m.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// list answers a ListObjectsV2 request with the page of objects after the
+// continuation token.
+func (m *s3StandIn) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("list-type") != "2" {
+		writeS3Error(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := slices.Sorted(func(yield func(string) bool) {
+		for key := range m.objects {
+			if !yield(key) {
+				return
+			}
+		}
+	})
+
+	start := 0
+	if token := query.Get("continuation-token"); token != "" {
+		m.tokens = append(m.tokens, token)
+		// Tokens look like "after <n>", with a space so they need escaping
+		n, err := strconv.Atoi(strings.TrimPrefix(token, "after "))
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "InvalidArgument")
+			return
+		}
+		start = n
+	}
+	end := min(start+testListPage, len(keys))
+
+	var listing strings.Builder
+	listing.WriteString(`<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+	for _, key := range keys[start:end] {
+		fmt.Fprintf(&listing, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", key, len(m.objects[key]))
+	}
+	if end < len(keys) {
+		fmt.Fprintf(&listing, "<IsTruncated>true</IsTruncated><NextContinuationToken>after %d</NextContinuationToken>", end)
+	} else {
+		listing.WriteString("<IsTruncated>false</IsTruncated>")
+	}
+	listing.WriteString("</ListBucketResult>")
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, listing.String())
+}
+
+// checkSignature verifies the AWS Signature Version 4 of a request the way
+// S3 does, from the headers it lists as signed.
+func (m *s3StandIn) checkSignature(r *http.Request) error {
+	if got := r.Header.Get("x-amz-content-sha256"); got != "UNSIGNED-PAYLOAD" {
+		return fmt.Errorf("x-amz-content-sha256 is %q, want UNSIGNED-PAYLOAD", got)
+	}
+	amzDate := r.Header.Get("x-amz-date")
+	signedAt, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil {
+		return fmt.Errorf("bad x-amz-date %q", amzDate)
+	}
+	if time.Since(signedAt).Abs() > 15*time.Minute {
+		return fmt.Errorf("x-amz-date %s is too far off", amzDate)
+	}
+
+	authorization := r.Header.Get("Authorization")
+	scope := signedAt.Format("20060102") + "/" + testRegion + "/s3/aws4_request"
+	prefix := "AWS4-HMAC-SHA256 Credential=" + testAccessKey + "/" + scope + ", SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature="
+	signature, ok := strings.CutPrefix(authorization, prefix)
+	if !ok {
+		return fmt.Errorf("unexpected Authorization header %q", authorization)
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		canonicalQuery(r.URL.RawQuery),
+		"host:" + r.Host + "\nx-amz-content-sha256:UNSIGNED-PAYLOAD\nx-amz-date:" + amzDate + "\n",
+		"host;x-amz-content-sha256;x-amz-date",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := []byte("AWS4" + testSecretKey)
+	for _, part := range []string{signedAt.Format("20060102"), testRegion, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	if want := hex.EncodeToString(hmacSHA256(key, stringToSign)); signature != want {
+		return fmt.Errorf("signature %s, want %s", signature, want)
+	}
+	return nil
+}
+
+// canonicalQuery is a query string the way SigV4 signs it: parameters
+// sorted and values escaped with spaces as %20, however the client sent them.
+func canonicalQuery(rawQuery string) string {
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery
+	}
+	var params []string
+	for _, name := range slices.Sorted(maps.Keys(query)) {
+		for _, value := range query[name] {
+			params = append(params, awsEscape(name)+"="+awsEscape(value))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code></Error>", code)
+}
+
+func TestS3BlobStoreObjects(t *testing.T) {
+	standIn, store := newS3StandIn(t)
+	content := []byte("encrypted bytes")
+	key := strings.Repeat("ab", 32)
+
+	if exists, err := store.Exists(key); err != nil || exists {
+		t.Fatalf("Exists before Put: %v, %v", exists, err)
+	}
+	if _, err := store.Get(key); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Get before Put: got error %v, want os.ErrNotExist", err)
+	}
+
+	if err := store.Put(key, bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if exists, err := store.Exists(key); err != nil || !exists {
+		t.Fatalf("Exists after Put: %v, %v", exists, err)
+	}
+	got := readBlob(t, store, key)
+	if !bytes.Equal(got, content) {
+		t.Fatalf("Get returned %q, want %q", got, content)
+	}
+
+	if err := store.Delete(key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if exists, err := store.Exists(key); err != nil || exists {
+		t.Fatalf("Exists after Delete: %v, %v", exists, err)
+	}
+
+	// Deleting what isn't there is fine, whether the store answers 204 or 404
+	if err := store.Delete(key); err != nil {
+		t.Errorf("Delete of a missing blob: %v", err)
+	}
+	standIn.notFoundDeletes = true
+	if err := store.Delete(key); err != nil {
+		t.Errorf("Delete of a missing blob answered with 404: %v", err)
+	}
+}
+
+func TestS3BlobStorePutEmpty(t *testing.T) {
+	standIn, store := newS3StandIn(t)
+	// Uploads hand Put readers of unknown length, like this one
+	if err := store.Put("empty", io.MultiReader(), 0); err != nil {
+		t.Fatalf("Put of empty content: %v", err)
+	}
+	if content, ok := standIn.objects["empty"]; !ok || len(content) != 0 {
+		t.Fatalf("stored %q, %v, want an empty object", content, ok)
+	}
+	if got := readBlob(t, store, "empty"); len(got) != 0 {
+		t.Errorf("Get returned %q, want nothing", got)
+	}
+}
+
+func TestS3BlobStoreCompose(t *testing.T) {
+	_, store := newS3StandIn(t)
+	parts := []string{"session.part0", "session.part1", "session.part2"}
+	var want []byte
+	for i, part := range parts {
+		content := bytes.Repeat([]byte{byte('a' + i)}, 1000+i)
+		if err := store.Put(part, bytes.NewReader(content), int64(len(content))); err != nil {
+			t.Fatalf("Put %s: %v", part, err)
+		}
+		want = append(want, content...)
+	}
+
+	if err := store.Compose("whole", parts, int64(len(want))); err != nil {
+		t.Fatalf("Compose: %v", err)
+	}
+	if got := readBlob(t, store, "whole"); !bytes.Equal(got, want) {
+		t.Errorf("composed blob is %d bytes, want the %d bytes of the parts", len(got), len(want))
+	}
+	for _, part := range parts {
+		if exists, err := store.Exists(part); err != nil || !exists {
+			t.Errorf("part %s should be left as it is: %v, %v", part, exists, err)
+		}
+	}
+
+	if err := store.Compose("broken", []string{parts[0], "missing"}, int64(len(want))); err == nil {
+		t.Error("Compose of a missing part succeeded")
+	}
+}
+
+func TestS3BlobStoreList(t *testing.T) {
+	standIn, store := newS3StandIn(t)
+	want := map[string]int64{}
+	for i := range 5 {
+		key := fmt.Sprintf("blob%d", i)
+		content := bytes.Repeat([]byte("x"), i)
+		if err := store.Put(key, bytes.NewReader(content), int64(len(content))); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+		want[key] = int64(i)
+	}
+
+	got := map[string]int64{}
+	err := store.List(func(key string, size int64) error {
+		got[key] = size
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("List returned %v, want %v", got, want)
+	}
+	for key, size := range want {
+		if got[key] != size {
+			t.Errorf("List returned %s with %d bytes, want %d", key, got[key], size)
+		}
+	}
+	if want := []string{"after 2", "after 4"}; !slices.Equal(standIn.tokens, want) {
+		t.Errorf("List asked for continuation tokens %q, want %q", standIn.tokens, want)
+	}
+
+	// An error from fn stops the listing
+	stop := errors.New("stop")
+	calls := 0
+	err = store.List(func(string, int64) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Errorf("List returned %v after %d call(s), want the error of the first", err, calls)
+	}
+}
+
+func TestS3BlobStoreRejectedSignature(t *testing.T) {
+	standIn, store := newS3StandIn(t)
+	standIn.badSignaturesExpected = true
+	store.secretKey = "wrong"
+
+	err := store.Put("key", strings.NewReader("content"), 7)
+	if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Errorf("Put with the wrong secret returned %v, want a SignatureDoesNotMatch error", err)
+	}
+	if len(standIn.objects) != 0 {
+		t.Error("the stand-in stored an object despite the bad signature")
+	}
+}
+
+func readBlob(t *testing.T, store BlobStore, key string) []byte {
+	t.Helper()
+	blob, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Get %s: %v", key, err)
+	}
+	defer blob.Close()
+	content, err := io.ReadAll(blob)
+	if err != nil {
+		t.Fatalf("reading %s: %v", key, err)
+	}
+	return content
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"errors"
+	"filevault/utils"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Search queries are a list of terms that must all match. A term is either
+// a bare word matching file names containing it, or a field compared with
+// a value:
+//
+//	name:*.pdf        name matches the glob, or contains the text, ignoring case
+//	name=report.pdf   name is exactly this, ignoring case
+//	size>10MB         size compared with >, >=, <, <= or =
+//	uploaded>2025-01-01
+//	                  upload date compared the same way, : or = match the day
+//	tag:invoice       file has the tag
+//	attr.project=x    attribute project has the value x
+//
+// Prefixing a term with - negates it. Terms are compiled to SQL conditions
+// with every value passed as a parameter.
+
+var ErrInvalidQuery = errors.New("Invalid search query")
+
+// searchOperators separate the field of a term from its value. A term is
+// split at the first one it contains, preferring ">=" over ">" and so on.
+var searchOperators = []string{">=", "<=", ">", "<", "=", ":"}
+
+// compileSearch turns a search query into an SQL condition on the files
+// table and its parameters.
+func compileSearch(query string) (string, []any, error) {
+	var conditions []string
+	var args []any
+	for _, term := range strings.Fields(query) {
+		negate := false
+		if strings.HasPrefix(term, "-") && len(term) > 1 {
+			negate = true
+			term = term[1:]
+		}
+
+		condition, termArgs, err := compileTerm(term)
+		if err != nil {
+			return "", nil, err
+		}
+		if negate {
+			condition = "NOT (" + condition + ")"
+		}
+		conditions = append(conditions, condition)
+		args = append(args, termArgs...)
+	}
+	if len(conditions) == 0 {
+		return "", nil, fmt.Errorf("%w: the query is empty", ErrInvalidQuery)
+	}
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+// compileTerm compiles a single term of a search query.
+func compileTerm(term string) (string, []any, error) {
+	field, operator, value := splitTerm(term)
+	if operator == "" {
+		condition, arg := nameCondition(term)
+		return condition, []any{arg}, nil
+	}
+	if value == "" {
+		return "", nil, fmt.Errorf("%w: %q has no value", ErrInvalidQuery, term)
+	}
+
+	key := field
+	field = strings.ToLower(field)
+	switch {
+	case field == "name":
+		switch operator {
+		case ":":
+			condition, arg := nameCondition(value)
+			return condition, []any{arg}, nil
+		case "=":
+			return "file_name = ? COLLATE NOCASE", []any{value}, nil
+		}
+
+	case field == "size":
+		size, err := utils.ParseSize(value)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+		}
+		if operator == ":" {
+			operator = "="
+		}
+		return "size " + operator + " ?", []any{size}, nil
+
+	case field == "uploaded":
+		day, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: dates are written as YYYY-MM-DD, not %q", ErrInvalidQuery, value)
+		}
+		start, end := sqliteTime(day), sqliteTime(day.AddDate(0, 0, 1))
+		uploaded := "julianday(uploaded_at)"
+		switch operator {
+		case ">":
+			return uploaded + " >= julianday(?)", []any{end}, nil
+		case ">=":
+			return uploaded + " >= julianday(?)", []any{start}, nil
+		case "<":
+			return uploaded + " < julianday(?)", []any{start}, nil
+		case "<=":
+			return uploaded + " < julianday(?)", []any{end}, nil
+		default:
+			return uploaded + " >= julianday(?) AND " + uploaded + " < julianday(?)", []any{start, end}, nil
+		}
+
+	case field == "tag":
+		if operator == ":" || operator == "=" {
+			return "id IN (SELECT file_id FROM file_tags WHERE tag = ?)", []any{normalizeTag(value)}, nil
+		}
+
+	case strings.HasPrefix(field, "attr."):
+		key = key[len("attr."):]
+		if key != "" && (operator == ":" || operator == "=") {
+			return "id IN (SELECT file_id FROM file_attributes WHERE key = ? AND value = ?)", []any{key, value}, nil
+		}
+
+	default:
+		return "", nil, fmt.Errorf("%w: unknown field %q", ErrInvalidQuery, field)
+	}
+	return "", nil, fmt.Errorf("%w: %s can't be compared with %s", ErrInvalidQuery, field, operator)
+}
+
+// splitTerm splits a term at its first operator. Terms without one are bare
+// words and have an empty operator.
+func splitTerm(term string) (field, operator, value string) {
+	index := -1
+	for _, op := range searchOperators {
+		if i := strings.Index(term, op); i > 0 && (index == -1 || i < index || (i == index && len(op) > len(operator))) {
+			index, operator = i, op
+		}
+	}
+	if index == -1 {
+		return "", "", term
+	}
+	return term[:index], operator, term[index+len(operator):]
+}
+
+// sqliteTime formats a time the way SQLite's date functions understand.
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// Search prints the user's files matching a query.
+func (s *FileService) Search(query string) error {
+	userId, err := s.currentUserID()
+	if err != nil {
+		return err
+	}
+	condition, args, err := compileSearch(query)
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.db.Query("SELECT id, file_name, size, "+storedSizeColumn+", uploaded_at FROM files WHERE user_id = ? AND deleted_at IS NULL AND ("+condition+") ORDER BY uploaded_at",
+		append([]any{userId}, args...)...)
+	if err != nil {
+		return fmt.Errorf("failed to search files: %w", err)
+	}
+	defer rows.Close()
+
+	count, err := printFiles(rows)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		fmt.Println("No files match")
+	}
+	return nil
+}
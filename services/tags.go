@@ -0,0 +1,187 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Files can be labelled with tags and key/value attributes. Both belong to
+// the file rather than the user, so everyone with write access to a file can
+// change them. Tags are case insensitive and stored in lower case.
+
+var (
+	ErrInvalidTag        = errors.New("Tags can't be empty or contain spaces or commas")
+	ErrInvalidAttribute  = errors.New("Attribute keys may only contain letters, digits, '.', '_' and '-'")
+	ErrTagNotFound       = errors.New("File doesn't have this tag")
+	ErrAttributeNotFound = errors.New("File doesn't have this attribute")
+)
+
+var attributeKeyPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// normalizeTag returns the form a tag is stored in.
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+func validTag(tag string) bool {
+	return tag != "" && !strings.ContainsAny(tag, " \t\n,")
+}
+
+// AddTags labels a file with one or more tags.
+func (s *FileService) AddTags(fileId string, tags []string) error {
+	access, err := s.authorize(fileId, actionWrite)
+	if err != nil {
+		return err
+	}
+	for i, tag := range tags {
+		tags[i] = normalizeTag(tag)
+		if !validTag(tags[i]) {
+			return fmt.Errorf("%w: %q", ErrInvalidTag, tag)
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	for _, tag := range tags {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO file_tags (file_id, tag) VALUES (?, ?)", fileId, tag); err != nil {
+			return fmt.Errorf("failed to add tag: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit tags: %w", err)
+	}
+	fmt.Printf("Tagged %s with %s\n", access.fileName, strings.Join(tags, ", "))
+	return nil
+}
+
+// RemoveTag removes a tag from a file.
+func (s *FileService) RemoveTag(fileId, tag string) error {
+	access, err := s.authorize(fileId, actionWrite)
+	if err != nil {
+		return err
+	}
+	tag = normalizeTag(tag)
+
+	result, err := s.db.Exec("DELETE FROM file_tags WHERE file_id = ? AND tag = ?", fileId, tag)
+	if err != nil {
+		return fmt.Errorf("failed to remove tag: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrTagNotFound
+	}
+	fmt.Printf("Removed tag %s from %s\n", tag, access.fileName)
+	return nil
+}
+
+// SetAttribute sets a key/value attribute of a file, replacing the value
+// the key had.
+func (s *FileService) SetAttribute(fileId, key, value string) error {
+	access, err := s.authorize(fileId, actionWrite)
+	if err != nil {
+		return err
+	}
+	if !attributeKeyPattern.MatchString(key) {
+		return fmt.Errorf("%w: %q", ErrInvalidAttribute, key)
+	}
+
+	_, err = s.db.Exec("INSERT INTO file_attributes (file_id, key, value) VALUES (?, ?, ?) ON CONFLICT (file_id, key) DO UPDATE SET value = excluded.value",
+		fileId, key, value)
+	if err != nil {
+		return fmt.Errorf("failed to set attribute: %w", err)
+	}
+	fmt.Printf("Set %s=%s on %s\n", key, value, access.fileName)
+	return nil
+}
+
+// RemoveAttribute removes an attribute from a file.
+func (s *FileService) RemoveAttribute(fileId, key string) error {
+	access, err := s.authorize(fileId, actionWrite)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec("DELETE FROM file_attributes WHERE file_id = ? AND key = ?", fileId, key)
+	if err != nil {
+		return fmt.Errorf("failed to remove attribute: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrAttributeNotFound
+	}
+	fmt.Printf("Removed attribute %s from %s\n", key, access.fileName)
+	return nil
+}
+
+// ListAttributes prints the tags and attributes of a file.
+func (s *FileService) ListAttributes(fileId string) error {
+	if _, err := s.authorize(fileId, actionRead); err != nil {
+		return err
+	}
+
+	tags, err := fileTags(s.db, fileId)
+	if err != nil {
+		return err
+	}
+	attributes, err := fileAttributes(s.db, fileId)
+	if err != nil {
+		return err
+	}
+
+	if len(tags) == 0 {
+		fmt.Println("Tags: none")
+	} else {
+		fmt.Printf("Tags: %s\n", strings.Join(tags, ", "))
+	}
+	if len(attributes) == 0 {
+		fmt.Println("Attributes: none")
+		return nil
+	}
+	fmt.Println("Attributes:")
+	for _, attribute := range attributes {
+		fmt.Printf("  %s=%s\n", attribute[0], attribute[1])
+	}
+	return nil
+}
+
+// fileTags returns the tags of a file in alphabetical order.
+func fileTags(db *sql.DB, fileId string) ([]string, error) {
+	rows, err := db.Query("SELECT tag FROM file_tags WHERE file_id = ? ORDER BY tag", fileId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tags: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, fmt.Errorf("failed to read tag: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// fileAttributes returns the key/value attributes of a file ordered by key.
+func fileAttributes(db *sql.DB, fileId string) ([][2]string, error) {
+	rows, err := db.Query("SELECT key, value FROM file_attributes WHERE file_id = ? ORDER BY key", fileId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attributes: %w", err)
+	}
+	defer rows.Close()
+
+	var attributes [][2]string
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("failed to read attribute: %w", err)
+		}
+		attributes = append(attributes, [2]string{key, value})
+	}
+	return attributes, rows.Err()
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"filevault/utils"
+	"fmt"
+	"time"
+)
+
+// Deleting a file only moves it to the trash by setting files.deleted_at.
+// Trashed files keep their versions and content until the trash is emptied
+// or they've been there longer than the configured retention period.
+
+var (
+	ErrNotInTrash = errors.New("File isn't in the trash")
+	ErrNameTaken  = errors.New("A file with this name already exists")
+)
+
+// trashedFile is a file waiting in the trash.
+type trashedFile struct {
+	FileId    string
+	FileName  string
+	Size      int64
+	DeletedAt time.Time
+}
+
+// trashFileRecord moves a file to the trash.
+func (s *FileService) trashFileRecord(fileId string) error {
+	result, err := s.db.Exec("UPDATE files SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", time.Now(), fileId)
+	if err != nil {
+		return fmt.Errorf("failed to move file to the trash: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrFileNotExistent
+	}
+	return nil
+}
+
+// trashedFiles returns the files in the trash, of a single user or of
+// everyone when userId is empty.
+func (s *FileService) trashedFiles(userId string) ([]trashedFile, error) {
+	query := "SELECT id, file_name, size, deleted_at FROM files WHERE deleted_at IS NOT NULL"
+	args := []any{}
+	if userId != "" {
+		query += " AND user_id = ?"
+		args = append(args, userId)
+	}
+	rows, err := s.db.Query(query+" ORDER BY deleted_at", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trash: %w", err)
+	}
+	defer rows.Close()
+
+	var trashed []trashedFile
+	for rows.Next() {
+		var file trashedFile
+		if err := rows.Scan(&file.FileId, &file.FileName, &file.Size, &file.DeletedAt); err != nil {
+			return nil, fmt.Errorf("failed to read trash: %w", err)
+		}
+		trashed = append(trashed, file)
+	}
+	return trashed, rows.Err()
+}
+
+// retention returns how long files stay in the trash.
+func (s *FileService) retention() time.Duration {
+	return time.Duration(s.config.TrashRetentionDays) * 24 * time.Hour
+}
+
+// PurgeExpiredTrash permanently deletes every file that has been in the
+// trash for longer than the retention period.
+func (s *FileService) PurgeExpiredTrash() error {
+	trashed, err := s.trashedFiles("")
+	if err != nil {
+		return err
+	}
+	purged := 0
+	for _, file := range trashed {
+		if time.Since(file.DeletedAt) < s.retention() {
+			continue
+		}
+		if err := s.deleteFileRecord(file.FileId); err != nil {
+			return err
+		}
+		purged++
+	}
+	if purged > 0 {
+		fmt.Printf("Purged %d file(s) from the trash after %d days\n", purged, s.config.TrashRetentionDays)
+	}
+	return nil
+}
+
+// ListTrash prints the files the user has in the trash.
+func (s *FileService) ListTrash() error {
+	userId, err := s.currentUserID()
+	if err != nil {
+		return err
+	}
+	if err := s.PurgeExpiredTrash(); err != nil {
+		return err
+	}
+
+	trashed, err := s.trashedFiles(userId)
+	if err != nil {
+		return err
+	}
+	fmt.Println("ID                                   | Name                   | Size      | Deleted At          | Purged On")
+	fmt.Println("-------------------------------------+------------------------+-----------+---------------------+------------")
+	if len(trashed) == 0 {
+		fmt.Println("The trash is empty")
+		return nil
+	}
+	for _, file := range trashed {
+		fmt.Printf("%-36s | %-22s | %-9s | %-19s | %s\n",
+			file.FileId,
+			file.FileName,
+			utils.GetSizeField(file.Size),
+			file.DeletedAt.Local().Format(time.DateTime),
+			file.DeletedAt.Add(s.retention()).Local().Format(time.DateOnly),
+		)
+	}
+	return nil
+}
+
+// RestoreFromTrash moves a file the user owns out of the trash.
+func (s *FileService) RestoreFromTrash(fileId string) error {
+	userId, err := s.currentUserID()
+	if err != nil {
+		return err
+	}
+	if fileId == "" {
+		return errors.New("file ID is missing")
+	}
+
+	var ownerId, folderId sql.NullString
+	var fileName string
+	var deletedAt sql.NullTime
+	err = s.db.QueryRow("SELECT user_id, folder_id, file_name, deleted_at FROM files WHERE id = ?", fileId).Scan(&ownerId, &folderId, &fileName, &deletedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrFileNotExistent
+		}
+		return fmt.Errorf("failed to query file: %w", err)
+	}
+	if !ownerId.Valid || ownerId.String != userId {
+		return ErrForbidden
+	}
+	if !deletedAt.Valid {
+		return ErrNotInTrash
+	}
+
+	// A file with the same name may have been uploaded in the meantime
+	var taken int
+	err = s.db.QueryRow("SELECT COUNT(*) FROM files WHERE user_id = ? AND folder_id IS ? AND file_name = ? AND deleted_at IS NULL", userId, folderId, fileName).Scan(&taken)
+	if err != nil {
+		return fmt.Errorf("failed to query files: %w", err)
+	}
+	if taken > 0 {
+		return fmt.Errorf("%w: %s. Delete or rename it first", ErrNameTaken, fileName)
+	}
+
+	if _, err := s.db.Exec("UPDATE files SET deleted_at = NULL WHERE id = ?", fileId); err != nil {
+		return fmt.Errorf("failed to restore file: %w", err)
+	}
+	fmt.Printf("File with ID %s has been restored from the trash\n", fileId)
+	return nil
+}
+
+// EmptyTrash permanently deletes every file the user has in the trash.
+func (s *FileService) EmptyTrash() error {
+	userId, err := s.currentUserID()
+	if err != nil {
+		return err
+	}
+
+	trashed, err := s.trashedFiles(userId)
+	if err != nil {
+		return err
+	}
+	for _, file := range trashed {
+		if err := s.deleteFileRecord(file.FileId); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Permanently deleted %d file(s) from the trash\n", len(trashed))
+	return nil
+}
@@ -0,0 +1,389 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Files larger than uploadPartSize are uploaded in parts. Every part is
+// encrypted on its own (see newPartEncryptReader) and stored as a blob of
+// its own, and the upload_sessions and upload_parts tables record the parts
+// stored so far. Once all are, they're composed into the file's blob and
+// removed. An interrupted upload keeps its parts, so vault upload --resume
+// only sends the missing ones, after checking the stored ones against the
+// hashes recorded for them.
+
+var (
+	ErrNoInterruptedUpload = errors.New("No interrupted upload to resume")
+	ErrUploadPaused        = errors.New("Upload interrupted, the parts stored so far are kept")
+)
+
+const (
+	// uploadPartSize is the size of the parts large files are uploaded in.
+	// It must be a multiple of encryptionChunkSize
+	uploadPartSize = 8 << 20
+	// uploadSessionExpiry is how long the parts of an interrupted upload
+	// are kept
+	uploadSessionExpiry = 7 * 24 * time.Hour
+)
+
+// uploadSession is an upload of a large file in parts.
+type uploadSession struct {
+	id         string
+	userId     string
+	folderId   string
+	fileName   string
+	sourcePath string
+	size       int64
+	modifiedAt time.Time
+	checksum   string
+	blobKey    string
+	// codec is what the content is compressed with, see compression.go.
+	// The parts are then cut from the compressed content, of
+	// compressedSize bytes with digest compressedChecksum
+	codec              string
+	compressedSize     int64
+	compressedChecksum string
+	// saved is whether the session is recorded in the database, so an
+	// interrupted upload can be resumed
+	saved bool
+}
+
+// length returns the size of the content the parts are cut from.
+func (session *uploadSession) length() int64 {
+	if session.codec != "" {
+		return session.compressedSize
+	}
+	return session.size
+}
+
+// partKey returns the blob key a part of an upload is stored under.
+func partKey(sessionId string, part int) string {
+	return fmt.Sprintf("%s.part%d", sessionId, part)
+}
+
+// partCount returns how many parts content of size bytes is uploaded in.
+func partCount(size int64) int {
+	return int((size + uploadPartSize - 1) / uploadPartSize)
+}
+
+// partLength returns the size of the plaintext of a part.
+func partLength(size int64, part int) int64 {
+	return min(size-int64(part)*uploadPartSize, uploadPartSize)
+}
+
+// encryptedPartSize returns the size of a part once encrypted. The first
+// part carries the header of the encrypted stream.
+func encryptedPartSize(size int64, part int) int64 {
+	length := partLength(size, part)
+	encrypted := length + (length+encryptionChunkSize-1)/encryptionChunkSize*gcmTagSize
+	if part == 0 {
+		encrypted += int64(len(encryptionMagic))
+	}
+	return encrypted
+}
+
+// uploadSessions returns the interrupted uploads of a user, oldest first.
+// With a source path only the upload of that file is returned.
+func (s *FileService) uploadSessions(userId, sourcePath string) ([]*uploadSession, error) {
+	query := "SELECT id, user_id, folder_id, file_name, source_path, size, modified_at, checksum, blob_key, codec, compressed_size, compressed_checksum FROM upload_sessions WHERE user_id = ?"
+	args := []any{userId}
+	if sourcePath != "" {
+		query += " AND source_path = ?"
+		args = append(args, sourcePath)
+	}
+	rows, err := s.db.Query(query+" ORDER BY created_at", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query uploads: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []*uploadSession
+	for rows.Next() {
+		session := &uploadSession{saved: true}
+		var folderId sql.NullString
+		err := rows.Scan(&session.id, &session.userId, &folderId, &session.fileName, &session.sourcePath,
+			&session.size, &session.modifiedAt, &session.checksum, &session.blobKey,
+			&session.codec, &session.compressedSize, &session.compressedChecksum)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read upload: %w", err)
+		}
+		session.folderId = folderId.String
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// saveUploadSession records an upload about to store the parts of a blob,
+// cut from length bytes of content. A resumed upload whose content changed,
+// or whose compressed content came out differently, starts over under a new
+// session.
+func (s *FileService) saveUploadSession(session *uploadSession, blob *writtenBlob, length int64, compressedChecksum string) error {
+	if session.saved {
+		switch {
+		case session.checksum != blob.checksum:
+			fmt.Printf("%s changed since the upload was interrupted, starting over\n", session.fileName)
+		case session.codec != blob.codec || session.compressedChecksum != compressedChecksum:
+			fmt.Printf("%s is compressed differently than when the upload was interrupted, starting over\n", session.fileName)
+		default:
+			return nil
+		}
+		if err := s.discardUploadSession(session); err != nil {
+			return err
+		}
+		session.id = uuid.New().String()
+	}
+
+	session.checksum, session.blobKey = blob.checksum, blob.key
+	session.codec, session.compressedChecksum, session.compressedSize = blob.codec, compressedChecksum, 0
+	if blob.codec != "" {
+		session.compressedSize = length
+	}
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	_, err := s.db.Exec(`INSERT INTO upload_sessions (id, user_id, folder_id, file_name, source_path, size, modified_at, checksum, blob_key, codec, compressed_size, compressed_checksum, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		session.id, session.userId, folderArg(session.folderId), session.fileName, session.sourcePath, session.size, session.modifiedAt,
+		session.checksum, session.blobKey, session.codec, session.compressedSize, session.compressedChecksum, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to record upload: %w", err)
+	}
+	session.saved = true
+	return nil
+}
+
+// deleteUploadSession removes the records of an upload. Its parts are
+// removed separately with removeParts once the transaction commits.
+func deleteUploadSession(tx *sql.Tx, sessionId string) error {
+	if _, err := tx.Exec("DELETE FROM upload_parts WHERE session_id = ?", sessionId); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM upload_sessions WHERE id = ?", sessionId)
+	return err
+}
+
+// discardUploadSession removes an upload and the parts it stored.
+func (s *FileService) discardUploadSession(session *uploadSession) error {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if err := deleteUploadSession(tx, session.id); err != nil {
+		return fmt.Errorf("failed to delete upload: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit upload deletion: %w", err)
+	}
+	session.saved = false
+	s.removeParts(session)
+	return nil
+}
+
+// removeParts removes the stored parts of an upload. Nothing references
+// them anymore, so failures only leave unused bytes behind.
+func (s *FileService) removeParts(session *uploadSession) {
+	for part := range partCount(session.length()) {
+		if err := s.store.Delete(partKey(session.id, part)); err != nil {
+			fmt.Printf("Warning: failed to remove part of the upload of %s: %v\n", session.fileName, err)
+			return
+		}
+	}
+}
+
+// storedParts returns the hashes of the parts an upload stored, checking
+// each against the store. Parts that are missing or don't match their hash
+// are forgotten so they're uploaded again.
+func (s *FileService) storedParts(session *uploadSession) (map[int]string, error) {
+	rows, err := s.db.Query("SELECT part, hash FROM upload_parts WHERE session_id = ?", session.id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query parts: %w", err)
+	}
+	parts := map[int]string{}
+	for rows.Next() {
+		var part int
+		var hash string
+		if err := rows.Scan(&part, &hash); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to read part: %w", err)
+		}
+		parts[part] = hash
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read parts: %w", err)
+	}
+
+	for part, hash := range parts {
+		if s.partIntact(partKey(session.id, part), hash) {
+			continue
+		}
+		delete(parts, part)
+		if err := s.forgetPart(session, part); err != nil {
+			return nil, err
+		}
+	}
+	return parts, nil
+}
+
+// recordPart records a part the upload stored.
+func (s *FileService) recordPart(session *uploadSession, part int, hash string) error {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	_, err := s.db.Exec("INSERT OR REPLACE INTO upload_parts (session_id, part, size, hash) VALUES (?, ?, ?, ?)",
+		session.id, part, encryptedPartSize(session.length(), part), hash)
+	if err != nil {
+		return fmt.Errorf("failed to record part: %w", err)
+	}
+	return nil
+}
+
+// forgetPart removes the record of a part so it's uploaded again.
+func (s *FileService) forgetPart(session *uploadSession, part int) error {
+	s.writes.Lock()
+	defer s.writes.Unlock()
+	_, err := s.db.Exec("DELETE FROM upload_parts WHERE session_id = ? AND part = ?", session.id, part)
+	if err != nil {
+		return fmt.Errorf("failed to forget part: %w", err)
+	}
+	return nil
+}
+
+// partIntact reports whether a stored part matches its recorded hash.
+func (s *FileService) partIntact(key, hash string) bool {
+	part, err := s.store.Get(key)
+	if err != nil {
+		return false
+	}
+	defer part.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, part); err != nil {
+		return false
+	}
+	return hex.EncodeToString(hasher.Sum(nil)) == hash
+}
+
+// putParts uploads the parts of content the session hasn't stored yet,
+// and composes them into the file's blob once they all are.
+func (s *FileService) putParts(session *uploadSession, r io.ReadSeeker, dataKey []byte) error {
+	stored, err := s.storedParts(session)
+	if err != nil {
+		return err
+	}
+	length := session.length()
+	parts := partCount(length)
+	if len(stored) > 0 {
+		fmt.Printf("Resuming the upload of %s, %d of %d parts are already stored\n", session.fileName, len(stored), parts)
+	}
+
+	transfer := s.track("Uploading "+session.fileName, length)
+	defer transfer.Finish()
+	for part := range stored {
+		transfer.Add(partLength(length, part))
+	}
+
+	key := contentKey(dataKey, session.checksum)
+	for part := range parts {
+		if _, ok := stored[part]; ok {
+			continue
+		}
+		offset := int64(part) * uploadPartSize
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		plaintext := progressReader{Reader: io.LimitReader(r, partLength(length, part)), transfer: transfer}
+		encrypted, err := newPartEncryptReader(key, plaintext, uint64(offset/encryptionChunkSize), part == parts-1)
+		if err != nil {
+			return err
+		}
+		hasher := sha256.New()
+		if err := s.store.Put(partKey(session.id, part), io.TeeReader(encrypted, hasher), encryptedPartSize(length, part)); err != nil {
+			return err
+		}
+		if err := s.recordPart(session, part, hex.EncodeToString(hasher.Sum(nil))); err != nil {
+			return err
+		}
+	}
+
+	keys := make([]string, parts)
+	for part := range parts {
+		keys[part] = partKey(session.id, part)
+	}
+	return s.store.Compose(session.blobKey, keys, encryptedSize(length))
+}
+
+// ResumeUpload continues the interrupted upload of a file, or of every
+// file whose upload was interrupted when pathname is empty.
+func (s *FileService) ResumeUpload(pathname string) error {
+	if pathname != "" {
+		uploaded, err := s.upload(pathname, "", true)
+		if err != nil {
+			return err
+		}
+		fmt.Println(uploaded)
+		return nil
+	}
+	userId, err := s.currentUserID()
+	if err != nil {
+		return err
+	}
+	sessions, err := s.uploadSessions(userId, "")
+	if err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		return ErrNoInterruptedUpload
+	}
+	for _, session := range sessions {
+		uploaded, err := s.upload(session.sourcePath, "", true)
+		if err != nil {
+			return fmt.Errorf("%s: %w", session.sourcePath, err)
+		}
+		fmt.Println(uploaded)
+	}
+	return nil
+}
+
+// PurgeStaleUploads removes the parts of uploads interrupted longer ago
+// than uploadSessionExpiry.
+func (s *FileService) PurgeStaleUploads() error {
+	rows, err := s.db.Query("SELECT id, file_name, size, codec, compressed_size, created_at FROM upload_sessions")
+	if err != nil {
+		return fmt.Errorf("failed to query uploads: %w", err)
+	}
+	var stale []*uploadSession
+	for rows.Next() {
+		session := &uploadSession{saved: true}
+		var createdAt time.Time
+		if err := rows.Scan(&session.id, &session.fileName, &session.size, &session.codec, &session.compressedSize, &createdAt); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read upload: %w", err)
+		}
+		if time.Since(createdAt) > uploadSessionExpiry {
+			stale = append(stale, session)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read uploads: %w", err)
+	}
+
+	for _, session := range stale {
+		if err := s.discardUploadSession(session); err != nil {
+			return err
+		}
+	}
+	if len(stale) > 0 {
+		fmt.Printf("Removed %d upload(s) interrupted more than %d days ago\n", len(stale), int(uploadSessionExpiry.Hours()/24))
+	}
+	return nil
+}
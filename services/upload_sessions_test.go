@@ -0,0 +1,253 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"filevault/db"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var errStoreUnavailable = errors.New("store unavailable")
+
+// memoryBlobStore keeps blobs in memory and counts how often each key is
+// written. Puts of the keys in failing fail, like a store going away in the
+// middle of an upload.
+type memoryBlobStore struct {
+	mu      sync.Mutex
+	blobs   map[string][]byte
+	puts    map[string]int
+	failing map[string]bool
+}
+
+func newMemoryBlobStore() *memoryBlobStore {
+	return &memoryBlobStore{blobs: map[string][]byte{}, puts: map[string]int{}, failing: map[string]bool{}}
+}
+
+func (m *memoryBlobStore) Put(key string, r io.Reader, size int64) error {
+	m.mu.Lock()
+	failing := m.failing[key]
+	m.mu.Unlock()
+	if failing {
+		return errStoreUnavailable
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if int64(len(content)) != size {
+		return fmt.Errorf("put %s: got %d bytes, expected %d", key, len(content), size)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[key] = content
+	m.puts[key]++
+	return nil
+}
+
+func (m *memoryBlobStore) Get(key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	content, ok := m.blobs[key]
+	if !ok {
+		return nil, fmt.Errorf("get %s: %w", key, os.ErrNotExist)
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (m *memoryBlobStore) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.blobs, key)
+	return nil
+}
+
+func (m *memoryBlobStore) Exists(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.blobs[key]
+	return ok, nil
+}
+
+func (m *memoryBlobStore) Compose(key string, parts []string, size int64) error {
+	return composeByCopy(m, key, parts, size)
+}
+
+func (m *memoryBlobStore) List(fn func(key string, size int64) error) error {
+	m.mu.Lock()
+	sizes := map[string]int64{}
+	for key, content := range m.blobs {
+		sizes[key] = int64(len(content))
+	}
+	m.mu.Unlock()
+	for key, size := range sizes {
+		if err := fn(key, size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partUpload is an upload in parts of random content, recorded in a fresh
+// database.
+type partUpload struct {
+	service *FileService
+	store   *memoryBlobStore
+	session *uploadSession
+	dataKey []byte
+	content []byte
+}
+
+func newPartUpload(t *testing.T, size int) *partUpload {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "filevault.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := db.Migrate(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &partUpload{
+		store:   newMemoryBlobStore(),
+		dataKey: testKey(t),
+		content: testPlaintext(t, size),
+	}
+	u.service = &FileService{db: conn, store: u.store}
+
+	digest := sha256.Sum256(u.content)
+	blob := &writtenBlob{checksum: hex.EncodeToString(digest[:]), size: int64(size)}
+	blob.key = encryptedBlobKey(u.dataKey, blob.checksum)
+	u.session = &uploadSession{
+		id:         uuid.New().String(),
+		userId:     "user",
+		fileName:   "big.bin",
+		sourcePath: "/tmp/big.bin",
+		size:       int64(size),
+		modifiedAt: time.Now(),
+	}
+	if err := u.service.saveUploadSession(u.session, blob, int64(size), ""); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// resume loads the session back from the database and uploads what's
+// missing, like vault upload --resume.
+func (u *partUpload) resume(t *testing.T) error {
+	t.Helper()
+	sessions, err := u.service.uploadSessions(u.session.userId, u.session.sourcePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("got %d upload sessions, want 1", len(sessions))
+	}
+	u.session = sessions[0]
+	return u.service.putParts(u.session, bytes.NewReader(u.content), u.dataKey)
+}
+
+// puts returns how often each part was written.
+func (u *partUpload) puts() []int {
+	u.store.mu.Lock()
+	defer u.store.mu.Unlock()
+	counts := make([]int, partCount(u.session.length()))
+	for part := range counts {
+		counts[part] = u.store.puts[partKey(u.session.id, part)]
+	}
+	return counts
+}
+
+// checkComposed checks the composed blob decrypts to the uploaded content.
+func (u *partUpload) checkComposed(t *testing.T) {
+	t.Helper()
+	blob, err := u.store.Get(u.session.blobKey)
+	if err != nil {
+		t.Fatalf("composed blob: %v", err)
+	}
+	defer blob.Close()
+	decrypted, err := newDecryptReader(contentKey(u.dataKey, u.session.checksum), blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(decrypted)
+	if err != nil {
+		t.Fatalf("decrypting the composed blob: %v", err)
+	}
+	if !bytes.Equal(content, u.content) {
+		t.Fatal("composed blob doesn't decrypt to the uploaded content")
+	}
+}
+
+func TestResumeSkipsStoredParts(t *testing.T) {
+	u := newPartUpload(t, 2*uploadPartSize+1000)
+
+	// The store goes away after the first part
+	u.store.failing[partKey(u.session.id, 1)] = true
+	if err := u.service.putParts(u.session, bytes.NewReader(u.content), u.dataKey); !errors.Is(err, errStoreUnavailable) {
+		t.Fatalf("got error %v, want errStoreUnavailable", err)
+	}
+	if got := u.puts(); got[0] != 1 || got[1] != 0 || got[2] != 0 {
+		t.Fatalf("interrupted upload wrote parts %v times", got)
+	}
+
+	u.store.failing = map[string]bool{}
+	if err := u.resume(t); err != nil {
+		t.Fatalf("resuming: %v", err)
+	}
+	if got := u.puts(); got[0] != 1 || got[1] != 1 || got[2] != 1 {
+		t.Errorf("resumed upload wrote parts %v times, want each once", got)
+	}
+	u.checkComposed(t)
+}
+
+func TestResumeReuploadsCorruptParts(t *testing.T) {
+	u := newPartUpload(t, 3*uploadPartSize)
+
+	u.store.failing[partKey(u.session.id, 2)] = true
+	if err := u.service.putParts(u.session, bytes.NewReader(u.content), u.dataKey); !errors.Is(err, errStoreUnavailable) {
+		t.Fatalf("got error %v, want errStoreUnavailable", err)
+	}
+	u.store.failing = map[string]bool{}
+
+	// A byte of the first part rots in storage while the upload is paused
+	u.store.blobs[partKey(u.session.id, 0)][100] ^= 1
+
+	if err := u.resume(t); err != nil {
+		t.Fatalf("resuming: %v", err)
+	}
+	if got := u.puts(); got[0] != 2 || got[1] != 1 || got[2] != 1 {
+		t.Errorf("resumed upload wrote parts %v times, want [2 1 1]", got)
+	}
+	u.checkComposed(t)
+}
+
+func TestResumeReuploadsMissingParts(t *testing.T) {
+	u := newPartUpload(t, 2*uploadPartSize+1)
+
+	u.store.failing[partKey(u.session.id, 2)] = true
+	if err := u.service.putParts(u.session, bytes.NewReader(u.content), u.dataKey); !errors.Is(err, errStoreUnavailable) {
+		t.Fatalf("got error %v, want errStoreUnavailable", err)
+	}
+	u.store.failing = map[string]bool{}
+	u.store.Delete(partKey(u.session.id, 1))
+
+	if err := u.resume(t); err != nil {
+		t.Fatalf("resuming: %v", err)
+	}
+	if got := u.puts(); got[0] != 1 || got[1] != 2 || got[2] != 1 {
+		t.Errorf("resumed upload wrote parts %v times, want [1 2 1]", got)
+	}
+	u.checkComposed(t)
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"filevault/utils"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Every upload of a file is kept as a version in the file_versions table.
+// Uploading a name the user already has adds a version to that file instead
+// of creating a new one, and the files row always mirrors the newest
+// version. Each version holds its own reference to the blob it points at.
+
+var (
+	ErrVersionNotFound       = errors.New("Version doesn't exist")
+	ErrVersionAlreadyCurrent = errors.New("Version is already the current version")
+	ErrVersionNotRestorable  = errors.New("Version was stored before versioning and can't be restored")
+)
+
+// addVersion records new content of a file as its newest version.
+func addVersion(tx *sql.Tx, fileId string, size int64, filePath, checksum, mimeType string, encrypted bool, codec string, uploadedAt time.Time) (int, error) {
+	var version int
+	err := tx.QueryRow("SELECT COALESCE(MAX(version), 0) + 1 FROM file_versions WHERE file_id = ?", fileId).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("INSERT INTO file_versions (file_id, version, size, file_path, checksum, mime_type, encrypted, codec, uploaded_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		fileId, version, size, filePath, checksum, mimeType, encrypted, codec, uploadedAt)
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// pruneVersions drops the oldest versions of a file beyond the configured
+// maximum. It returns the removals of content nothing references anymore,
+// to be run once the transaction commits.
+func (s *FileService) pruneVersions(tx *sql.Tx, fileId string) ([]func() error, error) {
+	if s.config.MaxVersions == 0 {
+		return nil, nil
+	}
+
+	rows, err := tx.Query("SELECT id, file_path, checksum FROM file_versions WHERE file_id = ? ORDER BY version DESC LIMIT -1 OFFSET ?",
+		fileId, s.config.MaxVersions)
+	if err != nil {
+		return nil, err
+	}
+	type prunedVersion struct {
+		id                 int64
+		filePath, checksum sql.NullString
+	}
+	var pruned []prunedVersion
+	for rows.Next() {
+		var version prunedVersion
+		if err := rows.Scan(&version.id, &version.filePath, &version.checksum); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		pruned = append(pruned, version)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var removals []func() error
+	for _, version := range pruned {
+		removal, err := s.releaseContent(tx, version.filePath.String, version.checksum.String)
+		if err != nil {
+			return nil, err
+		}
+		if removal != nil {
+			removals = append(removals, removal)
+		}
+		if _, err := tx.Exec("DELETE FROM file_versions WHERE id = ?", version.id); err != nil {
+			return nil, err
+		}
+	}
+	return removals, nil
+}
+
+// releaseContent drops a version's reference to its content. It returns a
+// function removing the bytes once nothing references them, to be run after
+// the transaction commits, or nil while other versions still use them.
+func (s *FileService) releaseContent(tx *sql.Tx, filePath, checksum string) (func() error, error) {
+	blobKey, err := storedBlobKey(tx, filePath, checksum)
+	if err != nil {
+		return nil, err
+	}
+	if blobKey == "" {
+		// Stored before blobs existed, the file has its own copy on disk
+		return func() error {
+			err := os.Remove(filePath)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}, nil
+	}
+
+	last, err := releaseBlob(tx, blobKey)
+	if err != nil || !last {
+		return nil, err
+	}
+	return func() error {
+		return s.store.Delete(blobKey)
+	}, nil
+}
+
+// runRemovals removes content released by a committed transaction. The
+// database no longer references it, so failures only leave unused bytes
+// behind and are reported rather than returned.
+func runRemovals(removals []func() error) {
+	for _, remove := range removals {
+		if err := remove(); err != nil {
+			fmt.Printf("Warning: failed to remove unused content: %v\n", err)
+		}
+	}
+}
+
+// ListVersions prints the versions kept for a file the user owns.
+func (s *FileService) ListVersions(fileId string) error {
+	if _, err := s.authorize(fileId, actionManage); err != nil {
+		return err
+	}
+
+	rows, err := s.db.Query("SELECT version, size, checksum, uploaded_at FROM file_versions WHERE file_id = ? ORDER BY version DESC", fileId)
+	if err != nil {
+		return fmt.Errorf("failed to query versions: %w", err)
+	}
+	defer rows.Close()
+
+	fmt.Println("Version | Size      | Checksum     | Uploaded At")
+	fmt.Println("--------+-----------+--------------+--------------------")
+	current := true
+	for rows.Next() {
+		var version int
+		var size int64
+		var checksum sql.NullString
+		var uploadedAt time.Time
+		if err := rows.Scan(&version, &size, &checksum, &uploadedAt); err != nil {
+			return fmt.Errorf("failed to read version: %w", err)
+		}
+		shortChecksum := checksum.String
+		if len(shortChecksum) > 12 {
+			shortChecksum = shortChecksum[:12]
+		}
+		marker := ""
+		if current {
+			marker = " (current)"
+			current = false
+		}
+		fmt.Printf("%-7d | %-9s | %-12s | %s%s\n", version, utils.GetSizeField(size), shortChecksum, uploadedAt, marker)
+	}
+	return rows.Err()
+}
+
+// RestoreVersion makes an earlier version of a file the current one. The
+// restored content is added as a new version so no history is lost.
+func (s *FileService) RestoreVersion(fileId string, version int) error {
+	access, err := s.authorize(fileId, actionManage)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var size int64
+	var filePath, checksum, mimeType sql.NullString
+	var encrypted bool
+	var codec string
+	err = tx.QueryRow("SELECT size, file_path, checksum, mime_type, encrypted, codec FROM file_versions WHERE file_id = ? AND version = ?", fileId, version).
+		Scan(&size, &filePath, &checksum, &mimeType, &encrypted, &codec)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrVersionNotFound
+		}
+		return fmt.Errorf("failed to query version: %w", err)
+	}
+	var latest int
+	if err := tx.QueryRow("SELECT MAX(version) FROM file_versions WHERE file_id = ?", fileId).Scan(&latest); err != nil {
+		return fmt.Errorf("failed to query versions: %w", err)
+	}
+	if version == latest {
+		return ErrVersionAlreadyCurrent
+	}
+
+	blobKey, err := storedBlobKey(tx, filePath.String, checksum.String)
+	if err != nil {
+		return fmt.Errorf("failed to query blob: %w", err)
+	}
+	if blobKey == "" {
+		return ErrVersionNotRestorable
+	}
+
+	uploadedAt := time.Now()
+	newVersion, err := addVersion(tx, fileId, size, filePath.String, checksum.String, mimeType.String, encrypted, codec, uploadedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record version: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE blobs SET ref_count = ref_count + 1 WHERE hash = ?", blobKey); err != nil {
+		return fmt.Errorf("failed to record blob reference: %w", err)
+	}
+	_, err = tx.Exec("UPDATE files SET size = ?, file_path = ?, checksum = ?, mime_type = ?, encrypted = ?, codec = ?, uploaded_at = ? WHERE id = ?",
+		size, filePath.String, checksum.String, mimeType, encrypted, codec, uploadedAt, fileId)
+	if err != nil {
+		return fmt.Errorf("failed to update file record: %w", err)
+	}
+	if err := s.resealGrants(tx, fileId, checksum.String, encrypted); err != nil {
+		return fmt.Errorf("failed to update grants: %w", err)
+	}
+	removals, err := s.pruneVersions(tx, fileId)
+	if err != nil {
+		return fmt.Errorf("failed to prune versions: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit restore: %w", err)
+	}
+	runRemovals(removals)
+	if s.contentIndexEnabled() {
+		if err := s.reindexFile(fileId, access.fileName, filePath.String, checksum.String, encrypted, codec); err != nil {
+			fmt.Printf("Warning: failed to index the restored content: %v\n", err)
+		}
+	}
+
+	fmt.Printf("Restored version %d of file %s as version %d\n", version, fileId, newVersion)
+	return nil
+}
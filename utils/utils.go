@@ -9,6 +9,8 @@ import (
 	"math"
 	"math/rand"
 	"os"
+	"strconv"
+	"strings"
 )
 
 func GetSizeField(byteSize int64) string {
@@ -34,6 +36,31 @@ func GetSizeField(byteSize int64) string {
 	return fmt.Sprintf("%.1f %s", fileSize, units[i])
 }
 
+// ParseSize converts a size like "10MB", "1.5 GB" or "512" (bytes) to bytes.
+// Units are powers of 1024, matching GetSizeField.
+func ParseSize(size string) (int64, error) {
+	units := map[string]float64{
+		"":   1,
+		"B":  1,
+		"KB": 1 << 10,
+		"MB": 1 << 20,
+		"GB": 1 << 30,
+		"TB": 1 << 40,
+	}
+
+	size = strings.ToUpper(strings.TrimSpace(size))
+	numberEnd := strings.LastIndexAny(size, "0123456789.") + 1
+	value, err := strconv.ParseFloat(size[:numberEnd], 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	multiplier, ok := units[strings.TrimSpace(size[numberEnd:])]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit in %q", size)
+	}
+	return int64(value * multiplier), nil
+}
+
 func GenerateRandomString(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	result := make([]byte, length)